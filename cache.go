@@ -0,0 +1,98 @@
+package clouddriveclient
+
+import (
+	"context"
+)
+
+// MetadataCache answers node lookups from a MetadataIndex kept up to date
+// from the changes feed. Until the index is complete (bootstrapped up to the
+// end of the feed) and on index misses, lookups go to the API.
+type MetadataCache struct {
+	Drive *CloudDrive
+	Index MetadataIndex
+	// Options are used for changes requests. Checkpoint is ignored.
+	Options ChangesOptions
+}
+
+func NewMetadataCache(d *CloudDrive, index MetadataIndex) *MetadataCache {
+	if index == nil {
+		index = NewNodeIndex()
+	}
+
+	return &MetadataCache{
+		Drive: d,
+		Index: index,
+	}
+}
+
+// Ready reports whether lookups can be answered from the index.
+func (c *MetadataCache) Ready() bool {
+	_, complete := c.Index.Checkpoint()
+	return complete
+}
+
+// Sync applies all pending changes to the index. The first Sync with an
+// empty index bootstraps it from the full changes feed.
+func (c *MetadataCache) Sync(ctx context.Context) error {
+	return c.watcher().Poll(ctx)
+}
+
+// Watcher returns a Watcher that keeps the cache in sync when run.
+func (c *MetadataCache) Watcher() *Watcher {
+	return c.watcher()
+}
+
+func (c *MetadataCache) watcher() *Watcher {
+	w := NewWatcher(c.Drive, &indexCheckpointStore{index: c.Index})
+	w.Options = c.Options
+	w.OnChanges = func(ctx context.Context, changes *Changes) error {
+		return c.Index.Apply(changes)
+	}
+	w.onEnd = c.Index.MarkComplete
+	return w
+}
+
+func (c *MetadataCache) LookupNodeById(ctx context.Context, nodeId string) (node *Node, err error) {
+	if c.Ready() {
+		if node, ok := c.Index.Node(nodeId); ok {
+			return node, nil
+		}
+	}
+
+	return c.Drive.LookupNodeById(ctx, nodeId)
+}
+
+func (c *MetadataCache) LookupNode(ctx context.Context, parentId string, name string) (node *Node, ok bool, err error) {
+	if c.Ready() {
+		if node, ok := c.Index.Child(parentId, name); ok {
+			return node, true, nil
+		}
+	}
+
+	return c.Drive.LookupNode(ctx, parentId, name)
+}
+
+func (c *MetadataCache) NodeChildren(ctx context.Context, parentId string) (nodes []*Node, err error) {
+	if c.Ready() {
+		if _, ok := c.Index.Node(parentId); ok {
+			return c.Index.Children(parentId), nil
+		}
+	}
+
+	return c.Drive.NodeChildren(ctx, parentId)
+}
+
+// indexCheckpointStore stores the checkpoint in the index itself, which
+// Apply already does.
+type indexCheckpointStore struct {
+	index MetadataIndex
+}
+
+func (s *indexCheckpointStore) LoadCheckpoint() (checkpoint string, err error) {
+	checkpoint, _ = s.index.Checkpoint()
+	return checkpoint, nil
+}
+
+func (s *indexCheckpointStore) SaveCheckpoint(checkpoint string) error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package clouddriveclient
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// verifyingReader computes md5 of everything read and returns a
+// *ChecksumMismatchError instead of io.EOF if it doesn't match.
+type verifyingReader struct {
+	reader io.ReadCloser
+	nodeId string
+	md5    string
+	hash   hash.Hash
+}
+
+// NewVerifyingReader wraps the full content of node nodeId (e.g. from
+// DownloadNode without a span) and checks it against md5 at EOF.
+func NewVerifyingReader(reader io.ReadCloser, nodeId string, md5Hex string) io.ReadCloser {
+	return &verifyingReader{
+		reader: reader,
+		nodeId: nodeId,
+		md5:    md5Hex,
+		hash:   md5.New(),
+	}
+}
+
+func (r *verifyingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+
+	r.hash.Write(p[:n])
+
+	if err == io.EOF {
+		actual := hex.EncodeToString(r.hash.Sum(nil))
+
+		if actual != r.md5 {
+			return n, &ChecksumMismatchError{
+				NodeId:   r.nodeId,
+				Expected: r.md5,
+				Actual:   actual,
+			}
+		}
+	}
+
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.reader.Close()
+}
+
+// hashingReader computes md5 of an upload body while it is being sent.
+type hashingReader struct {
+	reader io.Reader
+	hash   hash.Hash
+}
+
+func newHashingReader(reader io.Reader) *hashingReader {
+	return &hashingReader{
+		reader: reader,
+		hash:   md5.New(),
+	}
+}
+
+func (r *hashingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+
+	r.hash.Write(p[:n])
+
+	return n, err
+}
+
+func (r *hashingReader) md5() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/koofr/go-httpclient"
@@ -27,6 +28,16 @@ type CloudDrive struct {
 
 	ContentClient  *httpclient.HTTPClient
 	MetadataClient *httpclient.HTTPClient
+
+	DiskUsageCacheTTL time.Duration
+
+	diskUsageCache      map[diskUsageCacheKey]*diskUsageCacheEntry
+	diskUsageCacheMutex sync.Mutex
+
+	TempLinkTTL time.Duration
+
+	tempLinkCache      map[string]*TempLink
+	tempLinkCacheMutex sync.Mutex
 }
 
 func NewCloudDrive(auth *CloudDriveAuth, httpClient *http.Client) (d *CloudDrive, err error) {
@@ -45,6 +56,9 @@ func NewCloudDrive(auth *CloudDriveAuth, httpClient *http.Client) (d *CloudDrive
 		EndpointClient: endpointClient,
 		Auth:           auth,
 		MaxRetries:     DefaultMaxRetries,
+
+		DiskUsageCacheTTL: DefaultDiskUsageCacheTTL,
+		TempLinkTTL:       DefaultTempLinkTTL,
 	}
 
 	return d, nil
@@ -76,6 +90,12 @@ func (d *CloudDrive) InitEndpoint(contentURL string, metadataURL string) error {
 }
 
 func (d *CloudDrive) Request(client *httpclient.HTTPClient, request *httpclient.RequestData) (response *http.Response, err error) {
+	return d.request(client, request, nil)
+}
+
+// request sends request with retries. prepare, if set, is called with every
+// attempt's copy of request, e.g. to attach a fresh upload body.
+func (d *CloudDrive) request(client *httpclient.HTTPClient, request *httpclient.RequestData, prepare func(req *httpclient.RequestData) error) (response *http.Response, err error) {
 	retries := d.MaxRetries
 
 	canRetry := request.CanCopy()
@@ -94,10 +114,17 @@ func (d *CloudDrive) Request(client *httpclient.HTTPClient, request *httpclient.
 
 		if canRetry {
 			_, currentRequest = request.Copy()
+			currentRequest.Context = request.Context
 		} else {
 			currentRequest = request
 		}
 
+		if prepare != nil {
+			if err := prepare(currentRequest); err != nil {
+				return nil, err
+			}
+		}
+
 		token, err := d.Auth.ValidToken(authCtx)
 		if err != nil {
 			return nil, err
@@ -114,9 +141,7 @@ func (d *CloudDrive) Request(client *httpclient.HTTPClient, request *httpclient.
 		if err != nil {
 			if httpErr, ok := err.(httpclient.InvalidStatusError); ok {
 				if httpErr.Got == http.StatusTooManyRequests && retry+1 < retries {
-					seconds := rand.Intn(int(math.Pow(2, float64(retry))))
-
-					time.Sleep(time.Duration(seconds) * time.Second)
+					time.Sleep(retryDelay(retry))
 
 					continue
 				}
@@ -131,6 +156,12 @@ func (d *CloudDrive) Request(client *httpclient.HTTPClient, request *httpclient.
 	panic("unreachable")
 }
 
+func retryDelay(retry int) time.Duration {
+	seconds := rand.Intn(int(math.Pow(2, float64(retry))))
+
+	return time.Duration(seconds) * time.Second
+}
+
 func (d *CloudDrive) MetadataRequest(request *httpclient.RequestData) (response *http.Response, err error) {
 	if d.MetadataClient == nil {
 		return nil, fmt.Errorf("metadata client not initialized")
@@ -145,6 +176,23 @@ func (d *CloudDrive) ContentRequest(request *httpclient.RequestData) (response *
 	return d.Request(d.ContentClient, request)
 }
 
+func (d *CloudDrive) contentUploadRequest(request *httpclient.RequestData, body *uploadBody, extra map[string]string) (response *http.Response, err error) {
+	if d.ContentClient == nil {
+		return nil, fmt.Errorf("content client not initialized")
+	}
+
+	if !body.rewindable() {
+		if err := body.attach(request, extra); err != nil {
+			return nil, err
+		}
+		return d.Request(d.ContentClient, request)
+	}
+
+	return d.request(d.ContentClient, request, func(req *httpclient.RequestData) error {
+		return body.attach(req, extra)
+	})
+}
+
 func (d *CloudDrive) GetEndpoint(ctx context.Context) (e *Endpoint, err error) {
 	e = &Endpoint{}
 
@@ -200,10 +248,21 @@ func (d *CloudDrive) LookupRoot(ctx context.Context) (root *Node, err error) {
 }
 
 func (d *CloudDrive) LookupNode(ctx context.Context, parentId string, name string) (node *Node, ok bool, err error) {
+	return d.lookupNode(ctx, parentId, name, "")
+}
+
+// lookupAvailableNode is LookupNode ignoring trashed and purged nodes. They
+// are filtered on the server, an available node may come after a trashed
+// one with the same name.
+func (d *CloudDrive) lookupAvailableNode(ctx context.Context, parentId string, name string) (node *Node, ok bool, err error) {
+	return d.lookupNode(ctx, parentId, name, " AND status:"+NodeStatusAvailable)
+}
+
+func (d *CloudDrive) lookupNode(ctx context.Context, parentId string, name string, filters string) (node *Node, ok bool, err error) {
 	nameEscaped := strings.Replace(name, "\"", "\\\\", -1)
 
 	params := make(url.Values)
-	params.Set("filters", "parents:"+parentId+" AND name:\""+nameEscaped+"\"")
+	params.Set("filters", "parents:"+parentId+" AND name:\""+nameEscaped+"\""+filters)
 
 	nodes := &Nodes{}
 
@@ -251,6 +310,9 @@ func (d *CloudDrive) LookupNodeById(ctx context.Context, nodeId string) (node *N
 	if err != nil {
 		return nil, err
 	}
+
+	d.cacheTempLink(node)
+
 	return node, nil
 }
 
@@ -299,27 +361,119 @@ func (d *CloudDrive) NodeChildren(ctx context.Context, parentId string) (nodes [
 	return nodes, nil
 }
 
+// FindByMd5 returns all available (not trashed) files with content md5.
+func (d *CloudDrive) FindByMd5(ctx context.Context, md5 string) (nodes []*Node, err error) {
+	nextToken := ""
+
+	nodes = []*Node{}
+
+	for {
+		params := make(url.Values)
+		params.Set("filters", "contentProperties.md5:"+md5+" AND status:"+NodeStatusAvailable)
+		if nextToken != "" {
+			params.Set("startToken", nextToken)
+		}
+
+		ns := &Nodes{}
+
+		req := &httpclient.RequestData{
+			Context:        ctx,
+			Method:         "GET",
+			Path:           "/nodes",
+			Params:         params,
+			ExpectedStatus: []int{http.StatusOK},
+			RespEncoding:   httpclient.EncodingJSON,
+			RespValue:      &ns,
+		}
+
+		_, err = d.MetadataRequest(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ns.Nodes) == 0 {
+			break
+		}
+
+		nodes = append(nodes, ns.Nodes...)
+
+		if ns.NextToken == "" {
+			break
+		}
+
+		nextToken = ns.NextToken
+	}
+
+	return nodes, nil
+}
+
+// Changes returns all changes since checkpoint merged into one change set.
 func (d *CloudDrive) Changes(ctx context.Context, checkpoint string) (changes *Changes, err error) {
+	return d.ChangesWithOptions(ctx, &ChangesOptions{Checkpoint: checkpoint})
+}
+
+// ChangesWithOptions is like Changes with request options. With MaxNodes set
+// the result may not reach the end of the feed, which End reports.
+func (d *CloudDrive) ChangesWithOptions(ctx context.Context, opts *ChangesOptions) (changes *Changes, err error) {
+	changes = &Changes{
+		Nodes: []*Node{},
+	}
+
+	if opts != nil {
+		changes.Checkpoint = opts.Checkpoint
+	}
+
+	end, err := d.ChangesStream(ctx, opts, func(chunk *Changes) error {
+		changes.Nodes = append(changes.Nodes, chunk.Nodes...)
+		if chunk.Checkpoint != "" {
+			changes.Checkpoint = chunk.Checkpoint
+		}
+		if chunk.Reset {
+			changes.Reset = true
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes.End = end
+
+	return changes, nil
+}
+
+// ChangesStream reads the changes feed and calls fn for every change set as
+// it arrives. Each change set's Checkpoint can be persisted once fn has
+// processed it. end is true if the feed was read up to its end marker.
+func (d *CloudDrive) ChangesStream(ctx context.Context, opts *ChangesOptions, fn func(changes *Changes) error) (end bool, err error) {
+	if opts == nil {
+		opts = &ChangesOptions{}
+	}
+
+	reqValue := &changesRequest{
+		Checkpoint: opts.Checkpoint,
+		ChunkSize:  opts.ChunkSize,
+		MaxNodes:   opts.MaxNodes,
+	}
+
+	if opts.IncludePurged {
+		reqValue.IncludePurged = "true"
+	}
+
 	req := &httpclient.RequestData{
 		Context:        ctx,
 		Method:         "POST",
 		Path:           "/changes",
 		ExpectedStatus: []int{http.StatusOK},
-	}
-
-	if checkpoint != "" {
-		req.ReqEncoding = httpclient.EncodingJSON
-
-		req.ReqValue = struct {
-			Checkpoint string `json:"checkpoint"`
-		}{
-			Checkpoint: checkpoint,
-		}
+		ReqEncoding:    httpclient.EncodingJSON,
+		ReqValue:       reqValue,
 	}
 
 	res, err := d.MetadataRequest(req)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
 	defer res.Body.Close()
@@ -330,21 +484,32 @@ func (d *CloudDrive) Changes(ctx context.Context, checkpoint string) (changes *C
 		r, err = gzip.NewReader(r)
 
 		if err != nil {
-			return nil, err
+			return false, err
 		}
 	}
 
 	decoder := json.NewDecoder(r)
 
-	changes = &Changes{}
+	for {
+		changes := &Changes{}
 
-	err = decoder.Decode(changes)
+		err = decoder.Decode(changes)
 
-	if err != nil {
-		return nil, err
-	}
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
 
-	return changes, nil
+		if changes.End {
+			return true, nil
+		}
+
+		if err := fn(changes); err != nil {
+			return false, err
+		}
+	}
 }
 
 func (d *CloudDrive) CreateFolder(ctx context.Context, parentId string, name string) (node *Node, err error) {
@@ -397,6 +562,27 @@ func (d *CloudDrive) DeleteNode(ctx context.Context, nodeId string) (node *Node,
 	return node, nil
 }
 
+func (d *CloudDrive) RestoreNode(ctx context.Context, nodeId string) (node *Node, err error) {
+	node = &Node{}
+
+	req := &httpclient.RequestData{
+		Context:        ctx,
+		Method:         "POST",
+		Path:           "/trash/" + nodeId + "/restore",
+		ExpectedStatus: []int{http.StatusOK},
+		RespEncoding:   httpclient.EncodingJSON,
+		RespValue:      &node,
+	}
+
+	_, err = d.MetadataRequest(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
 func (d *CloudDrive) RenameNode(ctx context.Context, nodeId string, newName string) (node *Node, err error) {
 	rename := &NodeRename{
 		Name: newName,
@@ -452,7 +638,54 @@ func (d *CloudDrive) MoveNode(ctx context.Context, nodeId string, fromParentId s
 	return node, nil
 }
 
+func (d *CloudDrive) AddNodeParent(ctx context.Context, nodeId string, parentId string) (err error) {
+	req := &httpclient.RequestData{
+		Context:        ctx,
+		Method:         "PUT",
+		Path:           "/nodes/" + parentId + "/children/" + nodeId,
+		ExpectedStatus: []int{http.StatusOK},
+		RespConsume:    true,
+	}
+
+	_, err = d.MetadataRequest(req)
+
+	return err
+}
+
 func (d *CloudDrive) DownloadNode(ctx context.Context, nodeId string, span *ioutils.FileSpan) (reader io.ReadCloser, size int64, err error) {
+	return d.DownloadNodeWithOptions(ctx, nodeId, &DownloadOptions{Span: span})
+}
+
+func (d *CloudDrive) DownloadNodeWithOptions(ctx context.Context, nodeId string, opts *DownloadOptions) (reader io.ReadCloser, size int64, err error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+
+	span := opts.Span
+
+	res, err := d.downloadRequest(ctx, nodeId, span)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader = res.Body
+
+	if opts.Md5 != "" && span == nil {
+		reader = NewVerifyingReader(reader, nodeId, opts.Md5)
+	}
+
+	if opts.Progress != nil {
+		reader = &progressReadCloser{
+			progressReader: newProgressReader(reader, res.ContentLength, opts.Progress),
+			closer:         reader,
+		}
+	}
+
+	return reader, res.ContentLength, nil
+}
+
+// downloadRequest requests content of nodeId, only span of it if not nil.
+func (d *CloudDrive) downloadRequest(ctx context.Context, nodeId string, span *ioutils.FileSpan) (res *http.Response, err error) {
 	req := &httpclient.RequestData{
 		Context:        ctx,
 		Method:         "GET",
@@ -465,25 +698,81 @@ func (d *CloudDrive) DownloadNode(ctx context.Context, nodeId string, span *iout
 		req.Headers.Set("Range", fmt.Sprintf("bytes=%d-%d", span.Start, span.End))
 	}
 
-	res, err := d.ContentRequest(req)
+	return d.ContentRequest(req)
+}
 
+// rangeRequest requests content of nodeId from start to end. It fails with
+// ErrRangeIgnored unless the server responds with that range, so that
+// content is never read at the wrong offset.
+func (d *CloudDrive) rangeRequest(ctx context.Context, nodeId string, start int64, end int64) (body io.ReadCloser, err error) {
+	res, err := d.downloadRequest(ctx, nodeId, &ioutils.FileSpan{Start: start, End: end})
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return res.Body, res.ContentLength, nil
+	if err := checkContentRange(res, start, end); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	return res.Body, nil
 }
 
+// DownloadNodeByTempLink downloads content using the node's tempLink, which
+// is cached between calls. An expired cached link is refreshed once.
 func (d *CloudDrive) DownloadNodeByTempLink(ctx context.Context, nodeId string, span *ioutils.FileSpan) (reader io.ReadCloser, size int64, err error) {
-	node, err := d.LookupNodeById(ctx, nodeId)
+	res, err := d.tempLinkRequest(ctx, nodeId, nil, span)
 	if err != nil {
 		return nil, 0, err
 	}
 
+	return res.Body, res.ContentLength, nil
+}
+
+func (d *CloudDrive) tempLinkRequest(ctx context.Context, nodeId string, params url.Values, span *ioutils.FileSpan) (res *http.Response, err error) {
+	link, cached, err := d.tempLink(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = d.downloadTempLink(ctx, link, params, span)
+
+	if err != nil && cached && isExpiredTempLinkError(err) {
+		d.InvalidateTempLink(nodeId)
+
+		link, _, err = d.tempLink(ctx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err = d.downloadTempLink(ctx, link, params, span)
+	}
+
+	return res, err
+}
+
+func (d *CloudDrive) downloadTempLink(ctx context.Context, link *TempLink, params url.Values, span *ioutils.FileSpan) (res *http.Response, err error) {
+	fullURL := link.URL
+
+	if len(params) > 0 {
+		u, err := url.Parse(link.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		query := u.Query()
+		for k, vs := range params {
+			query[k] = vs
+		}
+		u.RawQuery = query.Encode()
+
+		fullURL = u.String()
+	}
+
 	req := &httpclient.RequestData{
 		Context:        ctx,
 		Method:         "GET",
-		FullURL:        node.TempLink,
+		FullURL:        fullURL,
 		ExpectedStatus: []int{http.StatusOK, http.StatusPartialContent},
 	}
 	if span != nil {
@@ -491,16 +780,83 @@ func (d *CloudDrive) DownloadNodeByTempLink(ctx context.Context, nodeId string,
 		req.Headers.Set("Range", fmt.Sprintf("bytes=%d-%d", span.Start, span.End))
 	}
 
-	res, err := d.ContentRequest(req)
+	return d.ContentRequest(req)
+}
+
+func (d *CloudDrive) UploadNode(ctx context.Context, parentId string, name string, reader io.Reader) (node *Node, err error) {
+	return d.UploadNodeWithOptions(ctx, parentId, name, reader, nil)
+}
+
+func (d *CloudDrive) UploadNodeWithOptions(ctx context.Context, parentId string, name string, reader io.Reader, opts *UploadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	return d.uploadNode(ctx, parentId, name, &uploadBody{reader: reader, progress: opts.Progress, md5: opts.Md5}, opts)
+}
 
+// UploadFile uploads size bytes from reader. Unlike UploadNode the content
+// can be read again, so the upload is retried like any other request. If
+// size is negative and reader is an *os.File, its size is used.
+func (d *CloudDrive) UploadFile(ctx context.Context, parentId string, name string, reader io.ReaderAt, size int64) (node *Node, err error) {
+	return d.UploadFileWithOptions(ctx, parentId, name, reader, size, nil)
+}
+
+func (d *CloudDrive) UploadFileWithOptions(ctx context.Context, parentId string, name string, reader io.ReaderAt, size int64, opts *UploadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	body, err := newUploadBodyAt(reader, size)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	return res.Body, res.ContentLength, nil
+	body.progress = opts.Progress
+	body.md5 = opts.Md5
+
+	return d.uploadNode(ctx, parentId, name, body, opts)
 }
 
-func (d *CloudDrive) UploadNode(ctx context.Context, parentId string, name string, reader io.Reader) (node *Node, err error) {
+func (d *CloudDrive) uploadNode(ctx context.Context, parentId string, name string, body *uploadBody, opts *UploadOptions) (node *Node, err error) {
+	precheckConflict := opts.Conflict != ConflictFail && !body.rewindable()
+
+	if opts.SkipIdentical || precheckConflict {
+		existing, ok, err := d.lookupNameHolder(ctx, parentId, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok && opts.SkipIdentical {
+			identical, err := body.identical(existing)
+			if err != nil {
+				return nil, err
+			}
+			if identical {
+				return existing, nil
+			}
+		}
+
+		if ok && precheckConflict {
+			return d.resolveUploadConflict(ctx, parentId, name, existing, body, opts, ErrNameAlreadyExists)
+		}
+	}
+
+	node, err = d.createFile(ctx, parentId, name, body, opts)
+
+	if cde, ok := isNameConflict(err); ok && opts.Conflict != ConflictFail && body.rewindable() {
+		existing, err := d.conflictingNode(ctx, parentId, name, cde)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.resolveUploadConflict(ctx, parentId, name, existing, body, opts, cde)
+	}
+
+	return node, err
+}
+
+func (d *CloudDrive) createFile(ctx context.Context, parentId string, name string, body *uploadBody, opts *UploadOptions) (node *Node, err error) {
 	create := &NodeCreate{
 		Name:    name,
 		Kind:    NodeKindFile,
@@ -514,7 +870,9 @@ func (d *CloudDrive) UploadNode(ctx context.Context, parentId string, name strin
 	}
 
 	params := make(url.Values)
-	params.Set("suppress", "deduplication")
+	if !opts.Deduplication {
+		params.Set("suppress", "deduplication")
+	}
 
 	node = &Node{}
 
@@ -532,15 +890,17 @@ func (d *CloudDrive) UploadNode(ctx context.Context, parentId string, name strin
 		"metadata": string(createJson),
 	}
 
-	err = req.UploadFileExtra("file", "file", reader, extra)
+	_, err = d.contentUploadRequest(req, body, extra)
 
 	if err != nil {
+		if cde, ok := IsCloudDriveError(err); ok && cde.Code == ErrorCodeDuplicate {
+			nodeId, _ := cde.ConflictingNodeId()
+			return nil, &DuplicateError{NodeId: nodeId, Err: cde}
+		}
 		return nil, err
 	}
 
-	_, err = d.ContentRequest(req)
-
-	if err != nil {
+	if err := body.verify(node); err != nil {
 		return nil, err
 	}
 
@@ -548,6 +908,60 @@ func (d *CloudDrive) UploadNode(ctx context.Context, parentId string, name strin
 }
 
 func (d *CloudDrive) OverwriteNode(ctx context.Context, nodeId string, reader io.Reader) (node *Node, err error) {
+	return d.OverwriteNodeWithOptions(ctx, nodeId, reader, nil)
+}
+
+// OverwriteNodeWithOptions overwrites content of nodeId. Deduplication and
+// Conflict in opts are ignored.
+func (d *CloudDrive) OverwriteNodeWithOptions(ctx context.Context, nodeId string, reader io.Reader, opts *UploadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	return d.overwriteNodeWithOptions(ctx, nodeId, &uploadBody{reader: reader, progress: opts.Progress, md5: opts.Md5}, opts)
+}
+
+// OverwriteFile is like UploadFile for OverwriteNode.
+func (d *CloudDrive) OverwriteFile(ctx context.Context, nodeId string, reader io.ReaderAt, size int64) (node *Node, err error) {
+	return d.OverwriteFileWithOptions(ctx, nodeId, reader, size, nil)
+}
+
+func (d *CloudDrive) OverwriteFileWithOptions(ctx context.Context, nodeId string, reader io.ReaderAt, size int64, opts *UploadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	body, err := newUploadBodyAt(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	body.progress = opts.Progress
+	body.md5 = opts.Md5
+
+	return d.overwriteNodeWithOptions(ctx, nodeId, body, opts)
+}
+
+func (d *CloudDrive) overwriteNodeWithOptions(ctx context.Context, nodeId string, body *uploadBody, opts *UploadOptions) (node *Node, err error) {
+	if opts.SkipIdentical {
+		existing, err := d.LookupNodeById(ctx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+
+		identical, err := body.identical(existing)
+		if err != nil {
+			return nil, err
+		}
+		if identical {
+			return existing, nil
+		}
+	}
+
+	return d.overwriteNode(ctx, nodeId, body)
+}
+
+func (d *CloudDrive) overwriteNode(ctx context.Context, nodeId string, body *uploadBody) (node *Node, err error) {
 	node = &Node{}
 
 	req := &httpclient.RequestData{
@@ -559,15 +973,13 @@ func (d *CloudDrive) OverwriteNode(ctx context.Context, nodeId string, reader io
 		RespValue:      &node,
 	}
 
-	err = req.UploadFile("file", "file", reader)
+	_, err = d.contentUploadRequest(req, body, nil)
 
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = d.ContentRequest(req)
-
-	if err != nil {
+	if err := body.verify(node); err != nil {
 		return nil, err
 	}
 
@@ -1,16 +1,30 @@
 package clouddriveclient
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"testing/fstest"
 	"time"
 
 	"github.com/koofr/go-ioutils"
@@ -28,6 +42,266 @@ func (t *testRoundTripper) RoundTrip(req *http.Request) (res *http.Response, err
 	return res, err
 }
 
+// fakeDrive is an in-memory Cloud Drive for specs that don't need a real
+// account. Like Cloud Drive it keeps trashed nodes in their parents, so
+// their names still conflict with new nodes.
+type fakeDrive struct {
+	mutex   sync.Mutex
+	ids     []string
+	nodes   map[string]*Node
+	content map[string][]byte
+	// ignoreRange serves the full content for range requests
+	ignoreRange bool
+}
+
+var fakeFilterRegexp = regexp.MustCompile(`(\w+):("[^"]*"|\S+)`)
+
+func newFakeDrive() *fakeDrive {
+	f := &fakeDrive{
+		nodes:   map[string]*Node{},
+		content: map[string][]byte{},
+	}
+
+	f.put(&Node{Id: "root", Kind: NodeKindFolder, IsRoot: true, Status: NodeStatusAvailable, Parents: []string{}})
+
+	return f
+}
+
+func (f *fakeDrive) put(node *Node) *Node {
+	if _, ok := f.nodes[node.Id]; !ok {
+		f.ids = append(f.ids, node.Id)
+	}
+	f.nodes[node.Id] = node
+	return node
+}
+
+func (f *fakeDrive) create(parentId string, name string, kind string) *Node {
+	return f.put(&Node{
+		Id:           fmt.Sprintf("n%d", len(f.ids)),
+		Name:         name,
+		Kind:         kind,
+		Parents:      []string{parentId},
+		Status:       NodeStatusAvailable,
+		ModifiedDate: time.Now().UTC(),
+	})
+}
+
+func (f *fakeDrive) write(node *Node, data []byte) {
+	sum := md5.Sum(data)
+
+	f.content[node.Id] = data
+	node.ContentProperties.Size = int64(len(data))
+	node.ContentProperties.Md5 = hex.EncodeToString(sum[:])
+	node.ModifiedDate = time.Now().UTC()
+	node.Version++
+}
+
+func (f *fakeDrive) addFolder(parentId string, name string) *Node {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.create(parentId, name, NodeKindFolder)
+}
+
+func (f *fakeDrive) addFile(parentId string, name string, data string) *Node {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	node := f.create(parentId, name, NodeKindFile)
+	f.write(node, []byte(data))
+
+	return node
+}
+
+func (f *fakeDrive) trash(nodeId string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.nodes[nodeId].Status = NodeStatusTrash
+}
+
+func (f *fakeDrive) node(nodeId string) *Node {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	node := *f.nodes[nodeId]
+
+	return &node
+}
+
+// children returns nodes in parentId in creation order, whatever their
+// status, optionally with the given name.
+func (f *fakeDrive) children(parentId string, name string) []*Node {
+	nodes := []*Node{}
+
+	for _, id := range f.ids {
+		node := f.nodes[id]
+
+		if len(node.Parents) == 0 || (name != "" && !strings.EqualFold(node.Name, name)) {
+			continue
+		}
+
+		for _, p := range node.Parents {
+			if p == parentId {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+
+	return nodes
+}
+
+func (f *fakeDrive) fail(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+}
+
+func (f *fakeDrive) reply(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// nameTaken fails the request if a node named name already is in parentId.
+func (f *fakeDrive) nameTaken(w http.ResponseWriter, parentId string, name string) bool {
+	if existing := f.children(parentId, name); len(existing) > 0 {
+		f.fail(w, http.StatusConflict, ErrorCodeNameAlreadyExists, "Node with the name "+name+" already exists under parentId "+parentId+" conflicting NodeId: "+existing[0].Id)
+		return true
+	}
+	return false
+}
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var node *Node
+	if len(parts) > 1 {
+		node = f.nodes[parts[1]]
+		if node == nil {
+			f.fail(w, http.StatusNotFound, ErrorCodeNodeNotFound, "Node "+parts[1]+" does not exist")
+			return
+		}
+	}
+
+	switch {
+	case r.Method == "GET" && len(parts) == 1 && parts[0] == "nodes":
+		filters := map[string]string{}
+		for _, m := range fakeFilterRegexp.FindAllStringSubmatch(r.URL.Query().Get("filters"), -1) {
+			filters[m[1]] = strings.Trim(m[2], `"`)
+		}
+
+		nodes := []*Node{}
+		for _, n := range f.children(filters["parents"], filters["name"]) {
+			if status, ok := filters["status"]; !ok || n.Status == status {
+				nodes = append(nodes, n)
+			}
+		}
+
+		f.reply(w, http.StatusOK, &Nodes{Nodes: nodes, Count: len(nodes)})
+
+	case r.Method == "GET" && len(parts) == 2 && parts[0] == "nodes":
+		f.reply(w, http.StatusOK, node)
+
+	case r.Method == "GET" && len(parts) == 3 && parts[2] == "children":
+		nodes := f.children(node.Id, "")
+		f.reply(w, http.StatusOK, &Nodes{Nodes: nodes, Count: len(nodes)})
+
+	case r.Method == "GET" && len(parts) == 3 && parts[2] == "content":
+		if f.ignoreRange {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.content[node.Id]))
+
+	case r.Method == "POST" && len(parts) == 1 && parts[0] == "nodes":
+		create := &NodeCreate{}
+		var data []byte
+
+		if file, _, err := r.FormFile("file"); err == nil {
+			data, _ = ioutil.ReadAll(file)
+			json.Unmarshal([]byte(r.FormValue("metadata")), create)
+		} else {
+			json.NewDecoder(r.Body).Decode(create)
+		}
+
+		if f.nameTaken(w, create.Parents[0], create.Name) {
+			return
+		}
+
+		created := f.create(create.Parents[0], create.Name, create.Kind)
+		if create.Kind == NodeKindFile {
+			f.write(created, data)
+		}
+
+		f.reply(w, http.StatusCreated, created)
+
+	case r.Method == "PUT" && len(parts) == 3 && parts[2] == "content":
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			f.fail(w, http.StatusBadRequest, "", err.Error())
+			return
+		}
+		data, _ := ioutil.ReadAll(file)
+
+		f.write(node, data)
+
+		f.reply(w, http.StatusOK, node)
+
+	case r.Method == "PATCH" && len(parts) == 2:
+		rename := &NodeRename{}
+		json.NewDecoder(r.Body).Decode(rename)
+
+		if f.nameTaken(w, node.Parents[0], rename.Name) {
+			return
+		}
+
+		node.Name = rename.Name
+
+		f.reply(w, http.StatusOK, node)
+
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "children":
+		move := &NodeMove{}
+		json.NewDecoder(r.Body).Decode(move)
+
+		child := f.nodes[move.ChildId]
+
+		if f.nameTaken(w, node.Id, child.Name) {
+			return
+		}
+
+		for i, p := range child.Parents {
+			if p == move.FromParent {
+				child.Parents[i] = node.Id
+			}
+		}
+
+		f.reply(w, http.StatusOK, child)
+
+	case r.Method == "PUT" && len(parts) == 4 && parts[2] == "children":
+		child := f.nodes[parts[3]]
+		child.Parents = append(child.Parents, node.Id)
+
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == "PUT" && len(parts) == 2 && parts[0] == "trash":
+		node.Status = NodeStatusTrash
+
+		f.reply(w, http.StatusOK, node)
+
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "trash" && parts[2] == "restore":
+		node.Status = NodeStatusAvailable
+
+		f.reply(w, http.StatusOK, node)
+
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "", r.Method+" "+r.URL.Path)
+	}
+}
+
 var _ = Describe("CloudDrive", func() {
 	var client *CloudDrive
 	var root *Node
@@ -129,6 +403,29 @@ var _ = Describe("CloudDrive", func() {
 		})
 	})
 
+	Describe("FindByMd5", func() {
+		It("should find nodes by content md5", func() {
+			folder := createFolder()
+			content := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), folder.Id, "file", strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			nodes, err := client.FindByMd5(context.Background(), node.ContentProperties.Md5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodes).To(HaveLen(1))
+			Expect(nodes[0].Id).To(Equal(node.Id))
+		})
+
+		It("should return no nodes for unknown md5", func() {
+			nodes, err := client.FindByMd5(context.Background(), "00000000000000000000000000000000")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodes).To(BeEmpty())
+		})
+	})
+
 	Describe("Changes", func() {
 		It("should get all changes", func() {
 			createFolder()
@@ -152,6 +449,54 @@ var _ = Describe("CloudDrive", func() {
 		})
 	})
 
+	Describe("Watcher", func() {
+		It("should stop on context cancellation", func() {
+			watcher := NewWatcher(client, nil)
+			watcher.Interval = 10 * time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			events := watcher.Events(ctx)
+
+			event := <-events
+			Expect(event.Err).NotTo(HaveOccurred())
+			Expect(event.Reset).To(BeTrue())
+			event.Done(nil)
+
+			cancel()
+
+			for range events {
+			}
+		})
+	})
+
+	Describe("FS", func() {
+		It("should expose a folder as fs.FS", func() {
+			folder := createFolder()
+
+			sub, err := client.CreateFolder(context.Background(), folder.Id, "sub")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.UploadNode(context.Background(), sub.Id, "file.txt", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			fsys := NewFS(context.Background(), client, folder.Id)
+
+			Expect(fstest.TestFS(fsys, "sub/file.txt")).To(Succeed())
+
+			data, err := fs.ReadFile(fsys, "sub/file.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("12345"))
+
+			info, err := fs.Stat(fsys, "sub")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.IsDir()).To(BeTrue())
+
+			_, err = fs.Stat(fsys, "missing")
+			Expect(errors.Is(err, fs.ErrNotExist)).To(BeTrue())
+		})
+	})
+
 	Describe("CreateFolder", func() {
 		It("should create a folder with parent id and name", func() {
 			name := fmt.Sprintf("%d", rand.Int())
@@ -176,6 +521,183 @@ var _ = Describe("CloudDrive", func() {
 		})
 	})
 
+	Describe("MkdirAll", func() {
+		It("should create missing folders and return the leaf", func() {
+			folder := createFolder()
+
+			node, err := client.MkdirAll(context.Background(), folder.Id, "a/b/c")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Name).To(Equal("c"))
+			Expect(node.Kind).To(Equal(NodeKindFolder))
+
+			time.Sleep(2 * time.Second)
+
+			again, err := client.MkdirAll(context.Background(), folder.Id, "a/b/c")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.Id).To(Equal(node.Id))
+		})
+
+		It("should not create a folder over an existing file", func() {
+			folder := createFolder()
+
+			_, err := client.UploadNode(context.Background(), folder.Id, "file", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			_, err = client.MkdirAll(context.Background(), folder.Id, "file/a")
+			Expect(err).To(Equal(ErrNotFolder))
+		})
+	})
+
+	Describe("Walk", func() {
+		It("should visit all nodes in a subtree", func() {
+			folder := createFolder()
+
+			_, err := client.MkdirAll(context.Background(), folder.Id, "a/b")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.MkdirAll(context.Background(), folder.Id, "c")
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			paths := []string{}
+
+			err = client.Walk(context.Background(), folder.Id, func(path string, node *Node, err error) error {
+				Expect(err).NotTo(HaveOccurred())
+				paths = append(paths, path)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(ConsistOf(".", "a", "a/b", "c"))
+		})
+
+		It("should skip folders", func() {
+			folder := createFolder()
+
+			_, err := client.MkdirAll(context.Background(), folder.Id, "a/b")
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			paths := []string{}
+
+			err = client.Walk(context.Background(), folder.Id, func(path string, node *Node, err error) error {
+				paths = append(paths, path)
+				if path == "a" {
+					return SkipDir
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(paths).To(Equal([]string{".", "a"}))
+		})
+	})
+
+	Describe("CopyTree", func() {
+		It("should copy a subtree", func() {
+			src := createFolder()
+			dest := createFolder()
+
+			a, err := client.MkdirAll(context.Background(), src.Id, "a")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.UploadNode(context.Background(), a.Id, "file", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			result, err := client.CopyTree(context.Background(), src.Id, dest.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Err()).NotTo(HaveOccurred())
+			Expect(result.Done).To(Equal(3))
+			Expect(result.Bytes).To(Equal(int64(5)))
+
+			time.Sleep(2 * time.Second)
+
+			node, ok, err := client.LookupNode(context.Background(), dest.Id, src.Name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(node.Kind).To(Equal(NodeKindFolder))
+		})
+	})
+
+	Describe("DiskUsage", func() {
+		It("should sum file sizes per child", func() {
+			folder := createFolder()
+
+			a, err := client.MkdirAll(context.Background(), folder.Id, "a")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.UploadNode(context.Background(), a.Id, "file1", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.UploadNode(context.Background(), folder.Id, "file2", strings.NewReader("abc"))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			usage, err := client.DiskUsage(context.Background(), folder.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(usage.Size).To(Equal(int64(8)))
+			Expect(usage.Files).To(Equal(2))
+			Expect(usage.Folders).To(Equal(1))
+			Expect(usage.Children).To(HaveLen(2))
+			Expect(usage.Children[0].Node.Name).To(Equal("a"))
+			Expect(usage.Children[0].Size).To(Equal(int64(5)))
+
+			cached, err := client.DiskUsage(context.Background(), a.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached.Size).To(Equal(int64(5)))
+		})
+	})
+
+	Describe("FindDuplicates", func() {
+		It("should group files by content and trash duplicates", func() {
+			folder := createFolder()
+			content := fmt.Sprintf("%d", rand.Int())
+
+			a, err := client.MkdirAll(context.Background(), folder.Id, "a")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = client.UploadNode(context.Background(), folder.Id, "file1", strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+			dup, err := client.UploadNode(context.Background(), a.Id, "file2", strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			report, err := client.FindDuplicates(context.Background(), folder.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Groups).To(HaveLen(1))
+			Expect(report.Reclaimable).To(Equal(int64(len(content))))
+
+			group := report.Groups[0]
+			Expect(group.Files).To(HaveLen(2))
+			Expect(group.Files[0].Path).To(Equal("a/file2"))
+			Expect(group.Files[1].Path).To(Equal("file1"))
+
+			result, err := client.ResolveDuplicates(context.Background(), report, DuplicateActionTrash, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Err()).NotTo(HaveOccurred())
+			Expect(result.Done).To(Equal(1))
+
+			time.Sleep(2 * time.Second)
+
+			node, err := client.LookupNodeById(context.Background(), dup.Id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Status).To(Equal(NodeStatusAvailable))
+		})
+	})
+
+	Describe("TrashTree", func() {
+		It("should trash nodes and report failures", func() {
+			folder := createFolder()
+
+			result, err := client.TrashTree(context.Background(), []string{folder.Id, "nonexistentid"}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Done).To(Equal(1))
+			Expect(result.Failures).To(HaveLen(1))
+			Expect(result.Failures[0].NodeId).To(Equal("nonexistentid"))
+		})
+	})
+
 	Describe("DeleteNode", func() {
 		It("should delete a node", func() {
 			folder := createFolder()
@@ -341,31 +863,290 @@ var _ = Describe("CloudDrive", func() {
 			Expect(string(data)).To(Equal("34"))
 		})
 
-		It("should not download a non-existent node", func() {
-			_, _, err := client.DownloadNode(context.Background(), "nonexistentid", nil)
-			Expect(err).To(HaveOccurred())
-			cde, ok := IsCloudDriveError(err)
-			Expect(ok).To(BeTrue())
-			Expect(cde.Code).To(Equal(ErrorCodeNodeNotFound))
-			Expect(cde.Message).To(Equal("Node does not exists"))
-		})
-	})
-
-	Describe("UploadNode", func() {
-		It("should upload a node", func() {
+		It("should report download progress of a range", func() {
 			name := fmt.Sprintf("%d", rand.Int())
 
 			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
 			Expect(err).NotTo(HaveOccurred())
-			Expect(node.Name).To(Equal(name))
-			Expect(node.ContentProperties.Size).To(Equal(int64(5)))
+
+			var last Progress
+
+			opts := &DownloadOptions{
+				Span: &ioutils.FileSpan{Start: 1, End: 3},
+				Progress: func(progress Progress) {
+					last = progress
+				},
+			}
+
+			reader, _, err := client.DownloadNodeWithOptions(context.Background(), node.Id, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			data, _ := ioutil.ReadAll(reader)
+			reader.Close()
+
+			Expect(string(data)).To(Equal("234"))
+			Expect(last.Transferred).To(Equal(int64(3)))
+			Expect(last.Total).To(Equal(int64(3)))
 		})
 
-		It("should not upload a node to a non-existent parent", func() {
+		It("should verify md5 of downloaded content", func() {
 			name := fmt.Sprintf("%d", rand.Int())
 
-			_, err := client.UploadNode(context.Background(), "nonexistentid", name, strings.NewReader("12345"))
-			Expect(err).To(HaveOccurred())
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			reader, _, err := client.DownloadNodeWithOptions(context.Background(), node.Id, &DownloadOptions{Md5: node.ContentProperties.Md5})
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadAll(reader)
+			reader.Close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("12345"))
+
+			reader, _, err = client.DownloadNodeWithOptions(context.Background(), node.Id, &DownloadOptions{Md5: "00000000000000000000000000000000"})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = ioutil.ReadAll(reader)
+			reader.Close()
+			cme, ok := IsChecksumMismatchError(err)
+			Expect(ok).To(BeTrue())
+			Expect(cme.NodeId).To(Equal(node.Id))
+			Expect(cme.Actual).To(Equal(node.ContentProperties.Md5))
+		})
+
+		It("should download a node in parallel ranges", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			f, err := ioutil.TempFile("", "clouddrive")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			state := &ParallelDownloadState{}
+
+			opts := &ParallelDownloadOptions{
+				ChunkSize: 2,
+				State:     state,
+			}
+
+			_, err = client.ParallelDownload(context.Background(), node.Id, f, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Done).To(Equal([]bool{true, true, true}))
+
+			data, _ := ioutil.ReadFile(f.Name())
+			Expect(string(data)).To(Equal("12345"))
+		})
+
+		It("should download a node resiliently", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			reader, size, err := client.DownloadNodeResilient(context.Background(), node.Id, &ResilientDownloadOptions{
+				Span: &ioutils.FileSpan{Start: 1, End: 4},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(int64(4)))
+
+			data, err := ioutil.ReadAll(reader)
+			reader.Close()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("2345"))
+		})
+
+		It("should not download a non-existent node", func() {
+			_, _, err := client.DownloadNode(context.Background(), "nonexistentid", nil)
+			Expect(err).To(HaveOccurred())
+			cde, ok := IsCloudDriveError(err)
+			Expect(ok).To(BeTrue())
+			Expect(cde.Code).To(Equal(ErrorCodeNodeNotFound))
+			Expect(cde.Message).To(Equal("Node does not exists"))
+		})
+	})
+
+	Describe("TempLink", func() {
+		It("should return a cached temp link usable without authentication", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			link, err := client.TempLink(context.Background(), node.Id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link.URL).NotTo(BeEmpty())
+			Expect(link.ExpiresAt).To(BeTemporally(">", time.Now()))
+
+			cached, err := client.TempLink(context.Background(), node.Id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached).To(Equal(link))
+
+			res, err := http.Get(link.URL)
+			Expect(err).NotTo(HaveOccurred())
+			data, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Expect(string(data)).To(Equal("12345"))
+		})
+	})
+
+	Describe("Thumbnail", func() {
+		It("should download a scaled image", func() {
+			var buf bytes.Buffer
+			err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 200, 100)))
+			Expect(err).NotTo(HaveOccurred())
+
+			name := fmt.Sprintf("%d.png", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, &buf)
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			reader, _, err := client.Thumbnail(context.Background(), node.Id, 50, 50)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			config, _, err := image.DecodeConfig(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config.Width).To(BeNumerically("<=", 50))
+			Expect(config.Height).To(BeNumerically("<=", 50))
+		})
+	})
+
+	Describe("OpenReader", func() {
+		It("should read, seek and read at offsets", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("0123456789"))
+			Expect(err).NotTo(HaveOccurred())
+
+			reader, err := client.OpenReader(context.Background(), node.Id)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			buf := make([]byte, 3)
+
+			_, err = io.ReadFull(reader, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("012"))
+
+			_, err = reader.Seek(2, io.SeekCurrent)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.ReadFull(reader, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("567"))
+
+			_, err = reader.Seek(-1, io.SeekEnd)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("9"))
+
+			n, err := reader.ReadAt(buf, 8)
+			Expect(err).To(Equal(io.EOF))
+			Expect(n).To(Equal(2))
+			Expect(string(buf[:n])).To(Equal("89"))
+		})
+	})
+
+	Describe("UploadNode", func() {
+		It("should upload a node", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, name, strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Name).To(Equal(name))
+			Expect(node.ContentProperties.Size).To(Equal(int64(5)))
+		})
+
+		It("should report upload progress", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			var last Progress
+
+			opts := &UploadOptions{
+				Progress: func(progress Progress) {
+					last = progress
+				},
+			}
+
+			_, err := client.UploadFileWithOptions(context.Background(), root.Id, name, strings.NewReader("12345"), 5, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(last.Transferred).To(Equal(int64(5)))
+			Expect(last.Total).To(Equal(int64(5)))
+			Expect(last.ETA).To(Equal(time.Duration(0)))
+		})
+
+		It("should resolve name conflicts with a policy", func() {
+			folder := createFolder()
+
+			existing, err := client.UploadNode(context.Background(), folder.Id, "file.txt", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			opts := &UploadOptions{Conflict: ConflictKeepBoth}
+
+			node, err := client.UploadFileWithOptions(context.Background(), folder.Id, "file.txt", strings.NewReader("123"), 3, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Name).To(Equal("file (1).txt"))
+
+			opts = &UploadOptions{Conflict: ConflictRemoteWins}
+
+			node, err = client.UploadFileWithOptions(context.Background(), folder.Id, "file.txt", strings.NewReader("123"), 3, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+			Expect(node.ContentProperties.Size).To(Equal(int64(5)))
+
+			opts = &UploadOptions{Conflict: ConflictLocalWins}
+
+			node, err = client.UploadNodeWithOptions(context.Background(), folder.Id, "file.txt", strings.NewReader("123"), opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+			Expect(node.ContentProperties.Size).To(Equal(int64(3)))
+		})
+
+		It("should skip uploading identical content", func() {
+			folder := createFolder()
+
+			existing, err := client.UploadNode(context.Background(), folder.Id, "file", strings.NewReader("12345"))
+			Expect(err).NotTo(HaveOccurred())
+
+			opts := &UploadOptions{SkipIdentical: true}
+
+			node, err := client.UploadFileWithOptions(context.Background(), folder.Id, "file", strings.NewReader("12345"), 5, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+			Expect(node.Version).To(Equal(existing.Version))
+
+			opts = &UploadOptions{SkipIdentical: true, Md5: existing.ContentProperties.Md5}
+
+			node, err = client.UploadNodeWithOptions(context.Background(), folder.Id, "file", strings.NewReader("12345"), opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+		})
+
+		It("should fail with duplicate error if deduplication is enabled", func() {
+			content := fmt.Sprintf("%d", rand.Int())
+
+			node, err := client.UploadNode(context.Background(), root.Id, fmt.Sprintf("%d", rand.Int()), strings.NewReader(content))
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(2 * time.Second)
+
+			opts := &UploadOptions{Deduplication: true}
+
+			_, err = client.UploadNodeWithOptions(context.Background(), root.Id, fmt.Sprintf("%d", rand.Int()), strings.NewReader(content), opts)
+			Expect(err).To(HaveOccurred())
+			de, ok := IsDuplicateError(err)
+			Expect(ok).To(BeTrue())
+			Expect(de.NodeId).To(Equal(node.Id))
+		})
+
+		It("should not upload a node to a non-existent parent", func() {
+			name := fmt.Sprintf("%d", rand.Int())
+
+			_, err := client.UploadNode(context.Background(), "nonexistentid", name, strings.NewReader("12345"))
+			Expect(err).To(HaveOccurred())
 			cde, ok := IsCloudDriveError(err)
 			Expect(ok).To(BeTrue())
 			Expect(cde.Code).To(Equal(ErrorCodeParentNodeIDNotFound))
@@ -373,6 +1154,36 @@ var _ = Describe("CloudDrive", func() {
 		})
 	})
 
+	Describe("PutFile", func() {
+		It("should create, overwrite and skip identical content", func() {
+			folder := createFolder()
+
+			node, err := client.PutFile(context.Background(), folder.Id, "file", strings.NewReader("12345"), 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.ContentProperties.Size).To(Equal(int64(5)))
+
+			same, err := client.PutFile(context.Background(), folder.Id, "file", strings.NewReader("12345"), 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(same.Id).To(Equal(node.Id))
+			Expect(same.Version).To(Equal(node.Version))
+
+			changed, err := client.PutFile(context.Background(), folder.Id, "file", strings.NewReader("123"), 3)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changed.Id).To(Equal(node.Id))
+			Expect(changed.ContentProperties.Size).To(Equal(int64(3)))
+		})
+
+		It("should not overwrite a folder", func() {
+			folder := createFolder()
+
+			_, err := client.CreateFolder(context.Background(), folder.Id, "sub")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.PutFile(context.Background(), folder.Id, "sub", strings.NewReader("12345"), 5)
+			Expect(err).To(Equal(ErrNotFile))
+		})
+	})
+
 	Describe("OverwriteNode", func() {
 		It("should overwrite a node", func() {
 			name := fmt.Sprintf("%d", rand.Int())
@@ -409,11 +1220,692 @@ var _ = Describe("CloudDrive", func() {
 			Expect(quota.Available).To(BeNumerically(">=", 0))
 		})
 	})
+})
+
+var _ = Describe("CloudDrive offline", func() {
+	var client *CloudDrive
+
+	BeforeEach(func() {
+		var err error
+
+		auth := &CloudDriveAuth{
+			AccessToken: "token",
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+
+		client, err = NewCloudDrive(auth, http.DefaultClient)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(client.InitEndpoint("http://127.0.0.1:1", "http://127.0.0.1:1")).To(Succeed())
+	})
+
+	var serveFakeDrive = func() (fake *fakeDrive, close func()) {
+		fake = newFakeDrive()
+
+		server := httptest.NewServer(fake)
+		Expect(client.InitEndpoint(server.URL, server.URL)).To(Succeed())
+
+		return fake, server.Close
+	}
+
+	Describe("MkdirAll", func() {
+		It("should reuse an available folder next to a trashed one with the same name", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			trashed := fake.addFolder("root", "a")
+			fake.trash(trashed.Id)
+			folder := fake.addFolder("root", "a")
+
+			node, err := client.MkdirAll(context.Background(), "root", "a/b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Name).To(Equal("b"))
+			Expect(node.Parents).To(Equal([]string{folder.Id}))
+		})
+	})
+
+	Describe("FS", func() {
+		It("should open an available file next to a trashed one with the same name", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			trashed := fake.addFile("root", "a.txt", "trashed")
+			fake.trash(trashed.Id)
+			fake.addFile("root", "a.txt", "available")
+
+			data, err := fs.ReadFile(NewFS(context.Background(), client, "root"), "a.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("available"))
+		})
+
+		It("should report names as stored in Cloud Drive", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			folder := fake.addFolder("root", "Folder")
+			fake.addFile(folder.Id, "File.txt", "data")
+
+			fsys := NewFS(context.Background(), client, "root")
+
+			info, err := fs.Stat(fsys, "folder/file.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Name()).To(Equal("File.txt"))
+
+			f, err := fsys.Open("FOLDER")
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+
+			info, err = f.Stat()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.Name()).To(Equal("Folder"))
+
+			Expect(fstest.TestFS(fsys, "Folder/File.txt")).To(Succeed())
+		})
+	})
+
+	Describe("UploadNode", func() {
+		It("should resolve conflicts with available files only", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			trashed := fake.addFile("root", "a.txt", "trashed")
+			fake.trash(trashed.Id)
+			existing := fake.addFile("root", "a.txt", "existing")
+
+			node, err := client.UploadNodeWithOptions(context.Background(), "root", "a.txt", strings.NewReader("new"), &UploadOptions{
+				Conflict: ConflictRemoteWins,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+
+			node, err = client.UploadFileWithOptions(context.Background(), "root", "a.txt", strings.NewReader("new"), 3, &UploadOptions{
+				Conflict: ConflictLocalWins,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(existing.Id))
+			Expect(fake.content[existing.Id]).To(Equal([]byte("new")))
+			Expect(fake.content[trashed.Id]).To(Equal([]byte("trashed")))
+		})
+
+		It("should restore and overwrite a trashed file holding the name", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			for i, policy := range []ConflictPolicy{ConflictRemoteWins, ConflictLocalWins, ConflictKeepBoth} {
+				name := fmt.Sprintf("%d.txt", i)
+
+				trashed := fake.addFile("root", name, "trashed")
+				fake.trash(trashed.Id)
+
+				opts := &UploadOptions{Conflict: policy}
+
+				node, err := client.UploadNodeWithOptions(context.Background(), "root", name, strings.NewReader("new"), opts)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(node.Id).To(Equal(trashed.Id))
+				Expect(node.Status).To(Equal(NodeStatusAvailable))
+				Expect(fake.content[trashed.Id]).To(Equal([]byte("new")))
+
+				fake.trash(trashed.Id)
+
+				node, err = client.UploadFileWithOptions(context.Background(), "root", name, strings.NewReader("newer"), 5, opts)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(node.Id).To(Equal(trashed.Id))
+				Expect(node.Status).To(Equal(NodeStatusAvailable))
+				Expect(fake.content[trashed.Id]).To(Equal([]byte("newer")))
+			}
+		})
+	})
+
+	Describe("PutFile", func() {
+		It("should overwrite a trashed file with the same name", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			trashed := fake.addFile("root", "a.txt", "same")
+			fake.trash(trashed.Id)
+
+			node, err := client.PutFile(context.Background(), "root", "a.txt", strings.NewReader("same"), 4)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(trashed.Id))
+			Expect(node.Status).To(Equal(NodeStatusAvailable))
+
+			fake.trash(trashed.Id)
+
+			node, err = client.PutFile(context.Background(), "root", "a.txt", strings.NewReader("other"), 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.Id).To(Equal(trashed.Id))
+			Expect(node.Status).To(Equal(NodeStatusAvailable))
+			Expect(fake.content[trashed.Id]).To(Equal([]byte("other")))
+		})
+
+		It("should not restore a trashed folder with the same name", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			trashed := fake.addFolder("root", "a")
+			fake.trash(trashed.Id)
+
+			_, err := client.PutFile(context.Background(), "root", "a", strings.NewReader("data"), 4)
+			cde, ok := IsCloudDriveError(err)
+			Expect(ok).To(BeTrue())
+			Expect(cde.Code).To(Equal(ErrorCodeNameAlreadyExists))
+			Expect(fake.node(trashed.Id).Status).To(Equal(NodeStatusTrash))
+		})
+	})
+
+	Describe("MoveTree", func() {
+		It("should merge into an existing folder", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			src := fake.addFolder("root", "src")
+			dst := fake.addFolder("root", "dst")
+
+			folder := fake.addFolder(src.Id, "d")
+			sub := fake.addFolder(folder.Id, "sub")
+			a := fake.addFile(folder.Id, "a.txt", "a")
+			b := fake.addFile(sub.Id, "b.txt", "b")
+
+			existing := fake.addFolder(dst.Id, "d")
+			existingSub := fake.addFolder(existing.Id, "sub")
+			fake.addFile(existing.Id, "c.txt", "c")
+
+			result, err := client.MoveTree(context.Background(), folder.Id, src.Id, dst.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Err()).NotTo(HaveOccurred())
+
+			Expect(fake.node(a.Id).Parents).To(Equal([]string{existing.Id}))
+			Expect(fake.node(b.Id).Parents).To(Equal([]string{existingSub.Id}))
+			Expect(fake.node(sub.Id).Status).To(Equal(NodeStatusTrash))
+			Expect(fake.node(folder.Id).Status).To(Equal(NodeStatusTrash))
+		})
+	})
+
+	Describe("OpenReader", func() {
+		It("should read, seek and read at offsets", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			node := fake.addFile("root", "a.txt", "0123456789")
+
+			reader, err := client.OpenReader(context.Background(), node.Id)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			_, err = reader.Seek(4, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(reader, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("456"))
+
+			n, err := reader.ReadAt(buf, 8)
+			Expect(err).To(Equal(io.EOF))
+			Expect(string(buf[:n])).To(Equal("89"))
+		})
+
+		It("should fail if the server ignores the range", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			node := fake.addFile("root", "a.txt", "0123456789")
+			fake.ignoreRange = true
+
+			reader, err := client.OpenReader(context.Background(), node.Id)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			_, err = reader.Seek(4, io.SeekStart)
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := make([]byte, 3)
+			_, err = reader.Read(buf)
+			Expect(err).To(Equal(ErrRangeIgnored))
+
+			_, err = reader.ReadAt(buf, 2)
+			Expect(err).To(Equal(ErrRangeIgnored))
+		})
+	})
+
+	Describe("ParallelDownload", func() {
+		It("should fail if the server ignores the range", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			node := fake.addFile("root", "a.txt", "12345")
+			fake.ignoreRange = true
+
+			f, err := ioutil.TempFile("", "clouddrive")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			state := &ParallelDownloadState{}
+
+			opts := &ParallelDownloadOptions{
+				Concurrency: 1,
+				ChunkSize:   2,
+				State:       state,
+			}
+
+			_, err = client.ParallelDownload(context.Background(), node.Id, f, opts)
+			Expect(err).To(Equal(ErrRangeIgnored))
+			Expect(state.Done).To(Equal([]bool{false, false, false}))
+
+			data, _ := ioutil.ReadFile(f.Name())
+			Expect(data).To(BeEmpty())
+		})
+	})
+
+	Describe("DownloadNodeResilient", func() {
+		// serveBrokenContent serves fake, cutting the first content response
+		// short after 5 bytes
+		var serveBrokenContent = func(fake *fakeDrive) (close func()) {
+			broken := false
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/content") && !broken {
+					broken = true
+					w.Header().Set("Content-Range", "bytes 0-9/10")
+					w.Header().Set("Content-Length", "10")
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write([]byte("01234"))
+					return
+				}
+				fake.ServeHTTP(w, r)
+			}))
+			Expect(client.InitEndpoint(server.URL, server.URL)).To(Succeed())
+
+			return server.Close
+		}
+
+		It("should resume a broken download", func() {
+			fake := newFakeDrive()
+			node := fake.addFile("root", "a.txt", "0123456789")
+			defer serveBrokenContent(fake)()
+
+			reader, size, err := client.DownloadNodeResilient(context.Background(), node.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+			Expect(size).To(Equal(int64(10)))
+
+			data, err := ioutil.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("0123456789"))
+		})
+
+		It("should fail if the server ignores the range when resuming", func() {
+			fake := newFakeDrive()
+			node := fake.addFile("root", "a.txt", "0123456789")
+			defer serveBrokenContent(fake)()
+
+			reader, _, err := client.DownloadNodeResilient(context.Background(), node.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			fake.ignoreRange = true
+
+			data, err := ioutil.ReadAll(reader)
+			Expect(err).To(Equal(ErrRangeIgnored))
+			Expect(string(data)).To(Equal("01234"))
+		})
+
+		It("should fail if the node changed when resuming", func() {
+			fake := newFakeDrive()
+			node := fake.addFile("root", "a.txt", "0123456789")
+			defer serveBrokenContent(fake)()
+
+			reader, _, err := client.DownloadNodeResilient(context.Background(), node.Id, nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+
+			fake.mutex.Lock()
+			fake.write(fake.nodes[node.Id], []byte("abcdefghij"))
+			fake.mutex.Unlock()
+
+			_, err = ioutil.ReadAll(reader)
+			Expect(err).To(Equal(ErrNodeChanged))
+		})
+
+		It("should end a span at the end of the content", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			node := fake.addFile("root", "a.txt", "0123456789")
+
+			reader, size, err := client.DownloadNodeResilient(context.Background(), node.Id, &ResilientDownloadOptions{
+				Span: &ioutils.FileSpan{Start: 5, End: 100},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer reader.Close()
+			Expect(size).To(Equal(int64(5)))
+
+			data, err := ioutil.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("56789"))
+		})
+	})
+
+	Describe("FindDuplicates", func() {
+		It("should skip trashed nodes and tell same-name folders apart", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			first := fake.addFolder("root", "x")
+			second := fake.addFolder("root", "x")
+			trashed := fake.addFolder("root", "trashed")
+
+			a := fake.addFile(first.Id, "a.txt", "same")
+			b := fake.addFile(second.Id, "b.txt", "same")
+			fake.addFile(trashed.Id, "c.txt", "same")
+			d := fake.addFile("root", "d.txt", "same")
+
+			fake.trash(trashed.Id)
+			fake.trash(d.Id)
+
+			report, err := client.FindDuplicates(context.Background(), "root", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Groups).To(HaveLen(1))
+
+			files := report.Groups[0].Files
+			Expect(files).To(HaveLen(2))
+			Expect(files[0].Node.Id).To(Equal(a.Id))
+			Expect(files[0].ParentId).To(Equal(first.Id))
+			Expect(files[1].Node.Id).To(Equal(b.Id))
+			Expect(files[1].ParentId).To(Equal(second.Id))
+
+			result, err := client.ResolveDuplicates(context.Background(), report, DuplicateActionLink, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Err()).NotTo(HaveOccurred())
+
+			Expect(fake.node(a.Id).Parents).To(Equal([]string{first.Id, second.Id}))
+			Expect(fake.node(b.Id).Status).To(Equal(NodeStatusTrash))
+		})
+	})
+
+	Describe("TrashTree", func() {
+		It("should report nodes by id", func() {
+			fake, done := serveFakeDrive()
+			defer done()
+
+			file := fake.addFile("root", "a.txt", "a")
+
+			progress := []TreeProgress{}
+
+			result, err := client.TrashTree(context.Background(), []string{file.Id, "nonexistentid"}, &TreeOptions{
+				Concurrency: 1,
+				OnProgress: func(p TreeProgress) {
+					progress = append(progress, p)
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Done).To(Equal(1))
+			Expect(result.Failures).To(HaveLen(1))
+			Expect(result.Failures[0].Path).To(BeEmpty())
+			Expect(result.Failures[0].NodeId).To(Equal("nonexistentid"))
+			Expect(result.Err()).To(MatchError(HavePrefix("nonexistentid: ")))
+
+			Expect(progress).To(HaveLen(2))
+			Expect(progress[0].Path).To(BeEmpty())
+			Expect(progress[0].NodeId).To(Equal(file.Id))
+
+			Expect(fake.node(file.Id).Status).To(Equal(NodeStatusTrash))
+		})
+	})
+
+	Describe("ChangesStream", func() {
+		It("should read all change sets up to the end marker", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				Expect(string(body)).To(MatchJSON(`{"checkpoint":"cp0","includePurged":"true","chunkSize":1}`))
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"checkpoint":"cp1","nodes":[{"id":"a"}],"reset":true}` + "\n"))
+				w.Write([]byte(`{"checkpoint":"cp2","nodes":[{"id":"b"}],"reset":false}` + "\n"))
+				w.Write([]byte(`{"end":true}` + "\n"))
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL)
+			client.MetadataClient.BaseURL = baseURL
+
+			opts := &ChangesOptions{
+				Checkpoint:    "cp0",
+				IncludePurged: true,
+				ChunkSize:     1,
+			}
+
+			checkpoints := []string{}
+
+			end, err := client.ChangesStream(context.Background(), opts, func(changes *Changes) error {
+				checkpoints = append(checkpoints, changes.Checkpoint)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(end).To(BeTrue())
+			Expect(checkpoints).To(Equal([]string{"cp1", "cp2"}))
+
+			changes, err := client.ChangesWithOptions(context.Background(), opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(changes.Checkpoint).To(Equal("cp2"))
+			Expect(changes.Nodes).To(HaveLen(2))
+			Expect(changes.Reset).To(BeTrue())
+			Expect(changes.End).To(BeTrue())
+		})
+	})
+
+	Describe("Watcher", func() {
+		It("should deliver changes and persist the checkpoint", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+
+				if strings.Contains(string(body), "cp1") {
+					w.Write([]byte(`{"checkpoint":"cp2","nodes":[{"id":"b"}],"reset":false}` + "\n"))
+				} else {
+					w.Write([]byte(`{"checkpoint":"cp1","nodes":[{"id":"a"}],"reset":true}` + "\n"))
+				}
+				w.Write([]byte(`{"end":true}` + "\n"))
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL)
+			client.MetadataClient.BaseURL = baseURL
+
+			store := &MemoryCheckpointStore{}
+			watcher := NewWatcher(client, store)
+
+			resets := 0
+			nodeIds := []string{}
+
+			watcher.OnReset = func(ctx context.Context) error {
+				resets++
+				nodeIds = []string{}
+				return nil
+			}
+			watcher.OnChanges = func(ctx context.Context, changes *Changes) error {
+				for _, node := range changes.Nodes {
+					nodeIds = append(nodeIds, node.Id)
+				}
+				return nil
+			}
+
+			Expect(watcher.Poll(context.Background())).To(Succeed())
+			Expect(watcher.Poll(context.Background())).To(Succeed())
+
+			Expect(resets).To(Equal(1))
+			Expect(nodeIds).To(Equal([]string{"a", "b"}))
+
+			checkpoint, err := store.LoadCheckpoint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkpoint).To(Equal("cp2"))
+		})
+
+		It("should save the checkpoint of an event once it is done", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(`{"checkpoint":"cp1","nodes":[{"id":"a"}],"reset":true}` + "\n"))
+				w.Write([]byte(`{"end":true}` + "\n"))
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL)
+			client.MetadataClient.BaseURL = baseURL
+
+			store := &MemoryCheckpointStore{}
+			watcher := NewWatcher(client, store)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events := watcher.Events(ctx)
+
+			event := <-events
+			Expect(event.Changes.Checkpoint).To(Equal("cp1"))
+
+			checkpoint, err := store.LoadCheckpoint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkpoint).To(BeEmpty())
+
+			event.Done(errors.New("not handled"))
+
+			event = <-events
+			Expect(event.Err).To(MatchError("not handled"))
+
+			checkpoint, err = store.LoadCheckpoint()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkpoint).To(BeEmpty())
+		})
+	})
+
+	Describe("MetadataCache", func() {
+		It("should answer lookups from the changes feed", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+
+				if strings.Contains(string(body), "cp1") {
+					w.Write([]byte(`{"checkpoint":"cp2","nodes":[{"id":"a","name":"A.txt","kind":"FILE","status":"AVAILABLE","parents":["f"]},{"id":"b","name":"b.txt","kind":"FILE","status":"TRASH","parents":["root"]}],"reset":false}` + "\n"))
+				} else {
+					w.Write([]byte(`{"checkpoint":"cp1","nodes":[{"id":"root","kind":"FOLDER","isRoot":true,"status":"AVAILABLE","parents":[]},{"id":"f","name":"f","kind":"FOLDER","status":"AVAILABLE","parents":["root"]},{"id":"a","name":"a.txt","kind":"FILE","status":"AVAILABLE","parents":["root"]},{"id":"b","name":"b.txt","kind":"FILE","status":"AVAILABLE","parents":["root"]}],"reset":true}` + "\n"))
+				}
+				w.Write([]byte(`{"end":true}` + "\n"))
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL)
+			client.MetadataClient.BaseURL = baseURL
+
+			cache := NewMetadataCache(client, nil)
+			Expect(cache.Ready()).To(BeFalse())
+
+			Expect(cache.Sync(context.Background())).To(Succeed())
+			Expect(cache.Ready()).To(BeTrue())
+
+			children, err := cache.NodeChildren(context.Background(), "root")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(children).To(HaveLen(3))
+
+			Expect(cache.Sync(context.Background())).To(Succeed())
+
+			checkpoint, complete := cache.Index.Checkpoint()
+			Expect(checkpoint).To(Equal("cp2"))
+			Expect(complete).To(BeTrue())
+
+			children, err = cache.NodeChildren(context.Background(), "root")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(children).To(HaveLen(1))
+			Expect(children[0].Id).To(Equal("f"))
+
+			node, ok, err := cache.LookupNode(context.Background(), "f", "a.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(node.Name).To(Equal("A.txt"))
+
+			node, err = cache.LookupNodeById(context.Background(), "root")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.IsRoot).To(BeTrue())
+		})
+	})
+
+	Describe("DiskIndex", func() {
+		It("should start empty if the snapshot is damaged", func() {
+			dir, err := ioutil.TempDir("", "clouddrive")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "snapshot.json"), []byte(`{"checkp`), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "journal.json"), []byte(`{"complete":true}`+"\n"), 0600)).To(Succeed())
+
+			index, err := OpenDiskIndex(dir)
+			Expect(err).NotTo(HaveOccurred())
+			defer index.Close()
+
+			checkpoint, complete := index.Checkpoint()
+			Expect(checkpoint).To(BeEmpty())
+			Expect(complete).To(BeFalse())
+		})
+
+		It("should resume from the persisted checkpoint", func() {
+			dir, err := ioutil.TempDir("", "clouddrive")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			index, err := OpenDiskIndex(dir)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(index.Apply(&Changes{
+				Checkpoint: "cp1",
+				Reset:      true,
+				Nodes: []*Node{
+					{Id: "root", Kind: NodeKindFolder, IsRoot: true, Status: NodeStatusAvailable},
+					{Id: "f", Name: "f", Kind: NodeKindFolder, Status: NodeStatusAvailable, Parents: []string{"root"}},
+				},
+			})).To(Succeed())
+			Expect(index.Compact()).To(Succeed())
+			Expect(index.Apply(&Changes{
+				Checkpoint: "cp2",
+				Nodes: []*Node{
+					{Id: "a", Name: "a.txt", Kind: NodeKindFile, Status: NodeStatusAvailable, Parents: []string{"f"}},
+				},
+			})).To(Succeed())
+			Expect(index.MarkComplete()).To(Succeed())
+
+			// simulate a journal record cut short by a crash
+			journal, err := os.OpenFile(filepath.Join(dir, "journal.json"), os.O_WRONLY|os.O_APPEND, 0600)
+			Expect(err).NotTo(HaveOccurred())
+			journal.Write([]byte(`{"changes":{"checkp`))
+			journal.Close()
+
+			index, err = OpenDiskIndex(dir)
+			Expect(err).NotTo(HaveOccurred())
+			defer index.Close()
+
+			checkpoint, complete := index.Checkpoint()
+			Expect(checkpoint).To(Equal("cp2"))
+			Expect(complete).To(BeTrue())
+
+			node, ok := index.LookupPath("root", "F/a.txt")
+			Expect(ok).To(BeTrue())
+			Expect(node.Id).To(Equal("a"))
+
+			path, ok := index.Path("a")
+			Expect(ok).To(BeTrue())
+			Expect(path).To(Equal("/f/a.txt"))
+
+			files := index.Search(func(node *Node) bool {
+				return node.Kind == NodeKindFile
+			})
+			Expect(files).To(HaveLen(1))
+		})
+	})
 
 	Describe("Errors", func() {
 		It("should handle Too many requests error", func() {
 			client.MaxRetries = 2
-			folder := createFolder()
 
 			retries := 0
 
@@ -428,7 +1920,7 @@ var _ = Describe("CloudDrive", func() {
 			baseURL, _ := url.Parse(server.URL)
 			client.MetadataClient.BaseURL = baseURL
 
-			_, err := client.NodeChildren(context.Background(), folder.Id)
+			_, err := client.NodeChildren(context.Background(), "folder")
 			Expect(err).To(HaveOccurred())
 			cde, ok := IsCloudDriveError(err)
 			Expect(ok).To(BeTrue())
@@ -439,7 +1931,6 @@ var _ = Describe("CloudDrive", func() {
 
 		It("should retry on Too many requests error", func() {
 			client.MaxRetries = 3
-			folder := createFolder()
 
 			retries := 0
 
@@ -460,10 +1951,43 @@ var _ = Describe("CloudDrive", func() {
 			baseURL, _ := url.Parse(server.URL)
 			client.MetadataClient.BaseURL = baseURL
 
-			children, err := client.NodeChildren(context.Background(), folder.Id)
+			children, err := client.NodeChildren(context.Background(), "folder")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(children).To(BeEmpty())
 			Expect(retries).To(Equal(3))
 		})
+
+		It("should retry file uploads on Too many requests error", func() {
+			client.MaxRetries = 3
+
+			retries := 0
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				retries++
+
+				file, _, err := r.FormFile("file")
+				Expect(err).NotTo(HaveOccurred())
+				data, _ := ioutil.ReadAll(file)
+				Expect(string(data)).To(Equal("12345"))
+
+				if retries == 3 {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusCreated)
+					w.Write([]byte(`{"id":"id","name":"name","contentProperties":{"size":5}}`))
+				} else {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					w.Write([]byte(`{"logref":"LOGREF-UUID","message":"Rate exceeded","code":""}`))
+				}
+			}))
+			defer server.Close()
+			baseURL, _ := url.Parse(server.URL)
+			client.ContentClient.BaseURL = baseURL
+
+			node, err := client.UploadFile(context.Background(), "root", "name", strings.NewReader("12345"), 5)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.ContentProperties.Size).To(Equal(int64(5)))
+			Expect(retries).To(Equal(3))
+		})
 	})
 })
@@ -0,0 +1,13 @@
+package clouddrivesync
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestClouddrivesync(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Clouddrivesync Suite")
+}
@@ -0,0 +1,163 @@
+package clouddrivesync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/koofr/go-clouddriveclient"
+)
+
+// tempPrefix is the name prefix of partial downloads, which are never
+// synced.
+const tempPrefix = ".clouddrivesync-"
+
+// LocalFile is a file or directory found in the local folder.
+type LocalFile struct {
+	Path    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	Md5     string
+}
+
+func (s *Syncer) ignored(p string, isDir bool) bool {
+	if strings.HasPrefix(path.Base(p), tempPrefix) {
+		return true
+	}
+
+	return s.Options.Ignore != nil && s.Options.Ignore(p, isDir)
+}
+
+// scanLocal lists the local folder. Files whose size and modification time
+// match state keep their recorded md5, others are hashed.
+func (s *Syncer) scanLocal(ctx context.Context, state *State) (files map[string]*LocalFile, err error) {
+	files = map[string]*LocalFile{}
+
+	err = filepath.Walk(s.LocalDir, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(s.LocalDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		p := filepath.ToSlash(rel)
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if s.ignored(p, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		file := &LocalFile{
+			Path:    p,
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+
+		if file.IsDir {
+			file.Size = 0
+		} else if entry, ok := state.Entries[p]; ok && !entry.IsDir && entry.Size == file.Size && entry.ModTime.Equal(file.ModTime) {
+			file.Md5 = entry.Md5
+		} else if file.Md5, err = fileMd5(fullPath); err != nil {
+			return err
+		}
+
+		files[p] = file
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func fileMd5(name string) (md5Hex string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := md5.New()
+
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// scanRemote lists the remote folder using the metadata cache. Pending
+// nodes and nodes other than files and folders are skipped.
+func (s *Syncer) scanRemote(ctx context.Context) (nodes map[string]*clouddriveclient.Node, err error) {
+	nodes = map[string]*clouddriveclient.Node{}
+
+	var scan func(parentId string, parentPath string) error
+
+	scan = func(parentId string, parentPath string) error {
+		children, err := s.Cache.NodeChildren(ctx, parentId)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range children {
+			if child.Status != clouddriveclient.NodeStatusAvailable {
+				continue
+			}
+
+			isDir := child.Kind == clouddriveclient.NodeKindFolder
+
+			if !isDir && child.Kind != clouddriveclient.NodeKindFile {
+				continue
+			}
+
+			p := path.Join(parentPath, child.Name)
+
+			if s.ignored(p, isDir) {
+				continue
+			}
+
+			nodes[p] = child
+
+			if isDir {
+				if err := scan(child.Id, p); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := scan(s.RemoteId, ""); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
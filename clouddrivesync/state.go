@@ -0,0 +1,113 @@
+package clouddrivesync
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is the state of a path after it was last synced. It is the common
+// ancestor in three-way comparisons of local and remote changes.
+type Entry struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDir"`
+	Md5   string `json:"md5"`
+	Size  int64  `json:"size"`
+	// ModTime of the local file, used to skip hashing unchanged files.
+	ModTime time.Time `json:"modTime"`
+	NodeId  string    `json:"nodeId"`
+	// ModifiedDate of the remote node.
+	ModifiedDate time.Time `json:"modifiedDate"`
+}
+
+// State maps slash separated paths relative to the synced folders to
+// entries.
+type State struct {
+	Entries map[string]*Entry `json:"entries"`
+}
+
+func NewState() *State {
+	return &State{
+		Entries: map[string]*Entry{},
+	}
+}
+
+type StateStore interface {
+	Load() (state *State, err error)
+	Save(state *State) error
+}
+
+type MemoryStateStore struct {
+	state *State
+	mutex sync.Mutex
+}
+
+func (s *MemoryStateStore) Load() (state *State, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state = NewState()
+
+	if s.state != nil {
+		for p, entry := range s.state.Entries {
+			e := *entry
+			state.Entries[p] = &e
+		}
+	}
+
+	return state, nil
+}
+
+func (s *MemoryStateStore) Save(state *State) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state = state
+
+	return nil
+}
+
+// FileStateStore keeps the state as JSON in file Path. A missing file means
+// an empty state.
+type FileStateStore struct {
+	Path string
+}
+
+func (s *FileStateStore) Load() (state *State, err error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return NewState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state = NewState()
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	if state.Entries == nil {
+		state.Entries = map[string]*Entry{}
+	}
+
+	return state, nil
+}
+
+func (s *FileStateStore) Save(state *State) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.Path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.Path)
+}
@@ -0,0 +1,796 @@
+// Package clouddrivesync reconciles a local directory with a Cloud Drive
+// folder.
+package clouddrivesync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/koofr/go-clouddriveclient"
+)
+
+type Direction int
+
+const (
+	// TwoWay propagates changes in both directions. Paths changed on both
+	// sides since the last sync are conflicts.
+	TwoWay Direction = iota
+	// Push makes the remote folder a mirror of the local one.
+	Push
+	// Pull makes the local folder a mirror of the remote one.
+	Pull
+)
+
+type ActionType string
+
+const (
+	ActionUpload       ActionType = "upload"
+	ActionDownload     ActionType = "download"
+	ActionMkdirLocal   ActionType = "mkdirLocal"
+	ActionMkdirRemote  ActionType = "mkdirRemote"
+	ActionDeleteLocal  ActionType = "deleteLocal"
+	ActionDeleteRemote ActionType = "deleteRemote"
+	ActionConflict     ActionType = "conflict"
+	// ActionKeepBoth renames the local file to a name generated by
+	// Options.ConflictName, uploads it and downloads the remote file.
+	ActionKeepBoth ActionType = "keepBoth"
+)
+
+// Action is a planned change of one path. Local and Remote are the states
+// found by the scan, either may be nil.
+type Action struct {
+	Type   ActionType
+	Path   string
+	Local  *LocalFile
+	Remote *clouddriveclient.Node
+	// Conflict is set if the action resolves a conflict.
+	Conflict bool
+}
+
+func (a *Action) String() string {
+	return fmt.Sprintf("%s %s", a.Type, a.Path)
+}
+
+type Options struct {
+	Direction Direction
+	// Ignore excludes paths (slash separated, relative to the synced
+	// folders) on both sides. Ignored directories are not descended into.
+	Ignore func(p string, isDir bool) bool
+	// Conflict resolves files changed on both sides in TwoWay mode. With
+	// ConflictFail they are reported in Result.Conflicts and left
+	// untouched. A file that was deleted on one side and modified on the
+	// other is kept by ConflictNewestWins and ConflictKeepBoth. Conflicts
+	// between a file and a directory are never resolved.
+	Conflict     clouddriveclient.ConflictPolicy
+	ConflictName clouddriveclient.ConflictNamer
+}
+
+type Failure struct {
+	Action *Action
+	Err    error
+}
+
+type Result struct {
+	Done      []*Action
+	Conflicts []*Action
+	Failures  []*Failure
+}
+
+// Err returns an error describing the failures, or nil.
+func (r *Result) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+
+	f := r.Failures[0]
+
+	return fmt.Errorf("clouddrivesync: %d actions failed, first: %s: %w", len(r.Failures), f.Action, f.Err)
+}
+
+// Syncer syncs LocalDir with the remote folder RemoteId.
+//
+// The remote tree is read from Cache, which follows the changes feed, so
+// after the first run only remote deltas are fetched. Change detection
+// compares md5 of files with the State recorded after the previous run,
+// remote files whose modifiedDate didn't change are not compared further.
+// Nodes that disappeared from the cache are looked up before their local
+// copies are deleted, in case the changes feed is lagging behind.
+type Syncer struct {
+	Drive    *clouddriveclient.CloudDrive
+	Cache    *clouddriveclient.MetadataCache
+	LocalDir string
+	RemoteId string
+	State    StateStore
+	Options  Options
+}
+
+// NewSyncer returns a Syncer with an in-memory metadata cache. Use a
+// MetadataCache with a DiskIndex to keep remote metadata between processes.
+func NewSyncer(d *clouddriveclient.CloudDrive, localDir string, remoteId string, state StateStore) *Syncer {
+	if state == nil {
+		state = &MemoryStateStore{}
+	}
+
+	return &Syncer{
+		Drive:    d,
+		Cache:    clouddriveclient.NewMetadataCache(d, nil),
+		LocalDir: localDir,
+		RemoteId: remoteId,
+		State:    state,
+	}
+}
+
+type plan struct {
+	state   *State
+	actions []*Action
+	local   map[string]*LocalFile
+	remote  map[string]*clouddriveclient.Node
+	// folders maps remote folder paths to node ids
+	folders map[string]string
+}
+
+// Plan scans both sides and returns the actions Run would perform.
+func (s *Syncer) Plan(ctx context.Context) (actions []*Action, err error) {
+	p, err := s.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.actions, nil
+}
+
+func (s *Syncer) plan(ctx context.Context) (p *plan, err error) {
+	state, err := s.State.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Cache.Sync(ctx); err != nil {
+		return nil, err
+	}
+
+	local, err := s.scanLocal(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := s.scanRemote(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p = &plan{
+		state:  state,
+		local:  local,
+		remote: remote,
+		folders: map[string]string{
+			"": s.RemoteId,
+		},
+	}
+
+	paths := map[string]struct{}{}
+	for name := range local {
+		paths[name] = struct{}{}
+	}
+	for name, node := range remote {
+		paths[name] = struct{}{}
+
+		if node.Kind == clouddriveclient.NodeKindFolder {
+			p.folders[name] = node.Id
+		}
+	}
+	for name := range state.Entries {
+		paths[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for name := range paths {
+		sorted = append(sorted, name)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return pathLess(sorted[i], sorted[j])
+	})
+
+	for _, name := range sorted {
+		l, r, base := local[name], remote[name], state.Entries[name]
+
+		if r == nil && base != nil && base.NodeId != "" {
+			r, err = s.lookupMissing(ctx, p, name, base)
+			if err != nil {
+				return nil, err
+			}
+			if r != nil {
+				remote[name] = r
+			}
+		}
+
+		actionType, ok := s.decide(l, r, base)
+
+		conflict := actionType == ActionConflict
+		if conflict {
+			actionType = s.resolveConflict(l, r)
+		}
+
+		if !ok {
+			// both sides agree, record them as synced
+			if l == nil {
+				delete(state.Entries, name)
+			} else {
+				state.Entries[name] = newEntry(l, r)
+			}
+			continue
+		}
+
+		p.actions = append(p.actions, &Action{
+			Type:     actionType,
+			Path:     name,
+			Local:    l,
+			Remote:   r,
+			Conflict: conflict,
+		})
+	}
+
+	p.actions = pruneDeletes(p.actions)
+
+	return p, nil
+}
+
+// lookupMissing checks a synced node the remote scan didn't find. The
+// changes feed may not have caught up with recent changes yet, so the node
+// only counts as deleted if it is gone from path p.
+func (s *Syncer) lookupMissing(ctx context.Context, pl *plan, p string, base *Entry) (node *clouddriveclient.Node, err error) {
+	node, err = s.Drive.LookupNodeById(ctx, base.NodeId)
+	if err != nil {
+		if cde, ok := clouddriveclient.IsCloudDriveError(err); ok && cde.Code == clouddriveclient.ErrorCodeNodeNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if node.Status != clouddriveclient.NodeStatusAvailable || node.Name != path.Base(p) {
+		return nil, nil
+	}
+
+	parentId, ok := pl.folders[parentPath(p)]
+	if !ok || !hasParent(node, parentId) {
+		return nil, nil
+	}
+
+	if isFolder(node) {
+		pl.folders[p] = node.Id
+	}
+
+	return node, nil
+}
+
+// decide returns the action for a path, ok is false if both sides are in
+// sync.
+func (s *Syncer) decide(l *LocalFile, r *clouddriveclient.Node, base *Entry) (actionType ActionType, ok bool) {
+	if inSync(l, r) {
+		return "", false
+	}
+
+	switch s.Options.Direction {
+	case Push:
+		return pushAction(l, r), true
+	case Pull:
+		return pullAction(l, r), true
+	}
+
+	localChanged := !localMatches(l, base)
+	remoteChanged := !remoteMatches(r, base)
+
+	switch {
+	case !localChanged && !remoteChanged:
+		// e.g. a remote file without md5 that wasn't modified
+		return "", false
+	case localChanged && !remoteChanged:
+		return pushAction(l, r), true
+	case remoteChanged && !localChanged:
+		return pullAction(l, r), true
+	}
+
+	return ActionConflict, true
+}
+
+func (s *Syncer) resolveConflict(l *LocalFile, r *clouddriveclient.Node) ActionType {
+	if (l != nil && l.IsDir) || (r != nil && isFolder(r)) {
+		return ActionConflict
+	}
+
+	policy := s.Options.Conflict
+
+	switch policy {
+	case clouddriveclient.ConflictFail:
+		return ActionConflict
+	case clouddriveclient.ConflictLocalWins:
+		return pushAction(l, r)
+	case clouddriveclient.ConflictRemoteWins:
+		return pullAction(l, r)
+	}
+
+	// a modification wins over a deletion
+	switch {
+	case l == nil:
+		return pullAction(l, r)
+	case r == nil:
+		return pushAction(l, r)
+	case policy == clouddriveclient.ConflictKeepBoth:
+		return ActionKeepBoth
+	case policy.LocalWins(l.ModTime, r):
+		return pushAction(l, r)
+	}
+
+	return pullAction(l, r)
+}
+
+func pushAction(l *LocalFile, r *clouddriveclient.Node) ActionType {
+	switch {
+	case l == nil:
+		return ActionDeleteRemote
+	case r != nil && l.IsDir != isFolder(r):
+		return ActionConflict
+	case l.IsDir:
+		return ActionMkdirRemote
+	}
+	return ActionUpload
+}
+
+func pullAction(l *LocalFile, r *clouddriveclient.Node) ActionType {
+	switch {
+	case r == nil:
+		return ActionDeleteLocal
+	case l != nil && l.IsDir != isFolder(r):
+		return ActionConflict
+	case isFolder(r):
+		return ActionMkdirLocal
+	}
+	return ActionDownload
+}
+
+// pruneDeletes keeps a directory deletion only if nothing else happens
+// under the directory, in which case deletions of its children are
+// dropped. actions must be sorted with pathLess, so that the actions under
+// a directory directly follow it.
+func pruneDeletes(actions []*Action) []*Action {
+	isDelete := func(a *Action) bool {
+		return a.Type == ActionDeleteLocal || a.Type == ActionDeleteRemote
+	}
+
+	isDeletedDir := func(a *Action) bool {
+		return isDelete(a) && ((a.Local != nil && a.Local.IsDir) || (a.Remote != nil && isFolder(a.Remote)))
+	}
+
+	keep := make([]bool, len(actions))
+	for i := range actions {
+		keep[i] = true
+	}
+
+	for i, a := range actions {
+		if !isDeletedDir(a) {
+			continue
+		}
+
+		prefix := a.Path + "/"
+
+		for j := i + 1; j < len(actions) && strings.HasPrefix(actions[j].Path, prefix); j++ {
+			if actions[j].Type != a.Type {
+				keep[i] = false
+				break
+			}
+		}
+
+		if keep[i] {
+			for j := i + 1; j < len(actions) && strings.HasPrefix(actions[j].Path, prefix); j++ {
+				keep[j] = false
+			}
+		}
+	}
+
+	pruned := []*Action{}
+
+	for i, a := range actions {
+		if keep[i] {
+			pruned = append(pruned, a)
+		}
+	}
+
+	return pruned
+}
+
+// Run syncs both folders. Failed actions are reported in the result and
+// retried on the next run. Conflicts are left untouched.
+func (s *Syncer) Run(ctx context.Context) (result *Result, err error) {
+	p, err := s.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result = &Result{}
+
+	for _, action := range p.actions {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+
+		if action.Type == ActionConflict {
+			result.Conflicts = append(result.Conflicts, action)
+			continue
+		}
+
+		if err := s.apply(ctx, p, action); err != nil {
+			result.Failures = append(result.Failures, &Failure{Action: action, Err: err})
+			continue
+		}
+
+		result.Done = append(result.Done, action)
+	}
+
+	if err := s.State.Save(p.state); err != nil {
+		return result, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *Syncer) localPath(p string) string {
+	return filepath.Join(s.LocalDir, filepath.FromSlash(p))
+}
+
+// remoteFolder returns the id of the remote folder at path p, creating it
+// if needed.
+func (s *Syncer) remoteFolder(ctx context.Context, pl *plan, p string) (nodeId string, err error) {
+	if nodeId, ok := pl.folders[p]; ok {
+		return nodeId, nil
+	}
+
+	node, err := s.Drive.MkdirAll(ctx, s.RemoteId, p)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.cacheNode(node); err != nil {
+		return "", err
+	}
+
+	pl.folders[p] = node.Id
+
+	return node.Id, nil
+}
+
+func (s *Syncer) apply(ctx context.Context, pl *plan, action *Action) error {
+	p := action.Path
+
+	switch action.Type {
+	case ActionMkdirRemote:
+		nodeId, err := s.remoteFolder(ctx, pl, p)
+		if err != nil {
+			return err
+		}
+		pl.state.Entries[p] = &Entry{Path: p, IsDir: true, NodeId: nodeId}
+
+	case ActionMkdirLocal:
+		if err := os.MkdirAll(s.localPath(p), 0755); err != nil {
+			return err
+		}
+		pl.state.Entries[p] = &Entry{Path: p, IsDir: true, NodeId: action.Remote.Id}
+
+	case ActionUpload:
+		node, err := s.upload(ctx, pl, action)
+		if err != nil {
+			return err
+		}
+		pl.state.Entries[p] = newEntry(action.Local, node)
+
+	case ActionDownload:
+		local, err := s.download(ctx, action)
+		if err != nil {
+			return err
+		}
+		pl.state.Entries[p] = newEntry(local, action.Remote)
+
+	case ActionKeepBoth:
+		return s.keepBoth(ctx, pl, action)
+
+	case ActionDeleteRemote:
+		node, err := s.Drive.DeleteNode(ctx, action.Remote.Id)
+		if err != nil {
+			return err
+		}
+		if err := s.cacheNode(node); err != nil {
+			return err
+		}
+		pl.state.forget(p)
+
+	case ActionDeleteLocal:
+		if err := s.deleteLocal(pl, p); err != nil {
+			return err
+		}
+		pl.state.forget(p)
+	}
+
+	return nil
+}
+
+// deleteLocal removes p and the scanned entries under it. Ignored files are
+// kept, along with the directories holding them.
+func (s *Syncer) deleteLocal(pl *plan, p string) error {
+	paths := []string{}
+
+	for name := range pl.local {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			paths = append(paths, name)
+		}
+	}
+
+	// children sort after their parents
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	for _, name := range paths {
+		err := os.Remove(s.localPath(name))
+		if err == nil || os.IsNotExist(err) {
+			continue
+		}
+
+		if pl.local[name].IsDir {
+			if entries, readErr := ioutil.ReadDir(s.localPath(name)); readErr == nil && len(entries) > 0 {
+				continue
+			}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// upload sends the local file and records the resulting node in the cache,
+// so that the next run doesn't depend on the changes feed to see it.
+func (s *Syncer) upload(ctx context.Context, pl *plan, action *Action) (node *clouddriveclient.Node, err error) {
+	f, err := os.Open(s.localPath(action.Path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if action.Remote != nil {
+		node, err = s.Drive.OverwriteFile(ctx, action.Remote.Id, f, action.Local.Size)
+	} else {
+		var parentId string
+		parentId, err = s.remoteFolder(ctx, pl, parentPath(action.Path))
+		if err != nil {
+			return nil, err
+		}
+
+		node, err = s.Drive.UploadFile(ctx, parentId, path.Base(action.Path), f, action.Local.Size)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.cacheNode(node); err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// cacheNode applies a node changed by the syncer to the metadata cache.
+func (s *Syncer) cacheNode(node *clouddriveclient.Node) error {
+	return s.Cache.Index.Apply(&clouddriveclient.Changes{
+		Nodes: []*clouddriveclient.Node{node},
+	})
+}
+
+func (s *Syncer) conflictName(name string, n int) string {
+	if s.Options.ConflictName != nil {
+		return s.Options.ConflictName(name, n)
+	}
+	return clouddriveclient.DefaultConflictName(name, n)
+}
+
+// keepBoth moves the local file to a name that is free on both sides and
+// syncs both files.
+func (s *Syncer) keepBoth(ctx context.Context, pl *plan, action *Action) error {
+	parent, name := parentPath(action.Path), path.Base(action.Path)
+
+	for n := 1; n <= clouddriveclient.MaxConflictNames; n++ {
+		altPath := path.Join(parent, s.conflictName(name, n))
+
+		if _, ok := pl.remote[altPath]; ok {
+			continue
+		}
+		if _, err := os.Lstat(s.localPath(altPath)); !os.IsNotExist(err) {
+			continue
+		}
+
+		if err := os.Rename(s.localPath(action.Path), s.localPath(altPath)); err != nil {
+			return err
+		}
+
+		local := *action.Local
+		local.Path = altPath
+
+		node, err := s.upload(ctx, pl, &Action{Type: ActionUpload, Path: altPath, Local: &local})
+		if err != nil {
+			return err
+		}
+		pl.state.Entries[altPath] = newEntry(&local, node)
+
+		downloaded, err := s.download(ctx, action)
+		if err != nil {
+			return err
+		}
+		pl.state.Entries[action.Path] = newEntry(downloaded, action.Remote)
+
+		return nil
+	}
+
+	return fmt.Errorf("clouddrivesync: no free conflict name for %s", action.Path)
+}
+
+// download writes the remote file to a temporary file next to the target
+// and renames it into place once the content is verified.
+func (s *Syncer) download(ctx context.Context, action *Action) (local *LocalFile, err error) {
+	target := s.localPath(action.Path)
+	dir := filepath.Dir(target)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	reader, _, err := s.Drive.DownloadNodeWithOptions(ctx, action.Remote.Id, &clouddriveclient.DownloadOptions{
+		Md5: action.Remote.ContentProperties.Md5,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	tmp, err := ioutil.TempFile(dir, tempPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := tmp.ReadFrom(reader)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	modTime := action.Remote.ModifiedDate
+
+	if !modTime.IsZero() {
+		if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+
+	local = &LocalFile{
+		Path:    action.Path,
+		Size:    size,
+		ModTime: info.ModTime(),
+		Md5:     action.Remote.ContentProperties.Md5,
+	}
+
+	return local, nil
+}
+
+// forget removes entries of p and everything under it.
+func (s *State) forget(p string) {
+	prefix := p + "/"
+
+	for name := range s.Entries {
+		if name == p || strings.HasPrefix(name, prefix) {
+			delete(s.Entries, name)
+		}
+	}
+}
+
+func newEntry(l *LocalFile, r *clouddriveclient.Node) *Entry {
+	entry := &Entry{
+		Path:    l.Path,
+		IsDir:   l.IsDir,
+		Md5:     l.Md5,
+		Size:    l.Size,
+		ModTime: l.ModTime,
+	}
+
+	if r != nil {
+		entry.NodeId = r.Id
+		entry.ModifiedDate = r.ModifiedDate
+	}
+
+	return entry
+}
+
+func parentPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+func hasParent(node *clouddriveclient.Node, parentId string) bool {
+	for _, id := range node.Parents {
+		if id == parentId {
+			return true
+		}
+	}
+	return false
+}
+
+// pathLess orders paths like strings, except that "/" sorts before any
+// other character. A directory is then directly followed by its subtree:
+// "docs", "docs/new.txt", "docs.txt".
+func pathLess(a string, b string) bool {
+	return strings.Replace(a, "/", "\x00", -1) < strings.Replace(b, "/", "\x00", -1)
+}
+
+func isFolder(node *clouddriveclient.Node) bool {
+	return node.Kind == clouddriveclient.NodeKindFolder
+}
+
+func inSync(l *LocalFile, r *clouddriveclient.Node) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	if l.IsDir != isFolder(r) {
+		return false
+	}
+	return l.IsDir || l.Md5 == r.ContentProperties.Md5
+}
+
+func localMatches(l *LocalFile, base *Entry) bool {
+	if l == nil || base == nil {
+		return l == nil && base == nil
+	}
+	if l.IsDir != base.IsDir {
+		return false
+	}
+	return l.IsDir || l.Md5 == base.Md5
+}
+
+// remoteMatches compares a remote node with the last synced state. An
+// unmodified node (same id and modifiedDate) matches without looking at
+// md5, which also covers nodes without md5.
+func remoteMatches(r *clouddriveclient.Node, base *Entry) bool {
+	if r == nil || base == nil {
+		return r == nil && base == nil
+	}
+	if isFolder(r) != base.IsDir {
+		return false
+	}
+	if base.IsDir {
+		return true
+	}
+	if r.Id == base.NodeId && !base.ModifiedDate.IsZero() && r.ModifiedDate.Equal(base.ModifiedDate) {
+		return true
+	}
+	return r.ContentProperties.Md5 != "" && r.ContentProperties.Md5 == base.Md5
+}
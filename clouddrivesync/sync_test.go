@@ -0,0 +1,372 @@
+package clouddrivesync
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koofr/go-clouddriveclient"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Syncer", func() {
+	var client *clouddriveclient.CloudDrive
+	var folder *clouddriveclient.Node
+	var dir string
+
+	auth := &clouddriveclient.CloudDriveAuth{
+		ClientId:     os.Getenv("CLOUDDRIVE_CLIENT_ID"),
+		ClientSecret: os.Getenv("CLOUDDRIVE_CLIENT_SECRET"),
+		RedirectUri:  os.Getenv("CLOUDDRIVE_REDIRECT_URI"),
+		AccessToken:  os.Getenv("CLOUDDRIVE_ACCESS_TOKEN"),
+		RefreshToken: os.Getenv("CLOUDDRIVE_REFRESH_TOKEN"),
+	}
+
+	if auth.ClientId == "" || auth.ClientSecret == "" || auth.RedirectUri == "" || auth.AccessToken == "" || auth.RefreshToken == "" || os.Getenv("CLOUDDRIVE_EXPIRES_AT") == "" {
+		fmt.Println("CLOUDDRIVE_CLIENT_ID, CLOUDDRIVE_CLIENT_SECRET, CLOUDDRIVE_ACCESS_TOKEN, CLOUDDRIVE_REFRESH_TOKEN, CLOUDDRIVE_EXPIRES_AT env variable missing")
+		return
+	}
+
+	exp, _ := strconv.ParseInt(os.Getenv("CLOUDDRIVE_EXPIRES_AT"), 10, 0)
+	auth.ExpiresAt = time.Unix(0, exp*1000000)
+
+	BeforeEach(func() {
+		var err error
+
+		rand.Seed(time.Now().UnixNano())
+
+		client, err = clouddriveclient.NewCloudDrive(auth, http.DefaultClient)
+		Expect(err).NotTo(HaveOccurred())
+
+		endpoint, err := client.GetEndpoint(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		client.InitEndpoint(endpoint.ContentUrl, endpoint.MetadataUrl)
+
+		root, err := client.LookupRoot(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		folder, err = client.CreateFolder(context.Background(), root.Id, fmt.Sprintf("%d", rand.Int()))
+		Expect(err).NotTo(HaveOccurred())
+
+		dir, err = ioutil.TempDir("", "clouddrivesync")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should sync both ways", func() {
+		Expect(os.MkdirAll(filepath.Join(dir, "a"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(dir, "a", "local.txt"), []byte("local"), 0644)).To(Succeed())
+
+		_, err := client.UploadNode(context.Background(), folder.Id, "remote.txt", strings.NewReader("remote"))
+		Expect(err).NotTo(HaveOccurred())
+
+		syncer := NewSyncer(client, dir, folder.Id, nil)
+
+		// the changes feed may take a moment to include the uploaded file
+		Eventually(func() []*Action {
+			actions, err := syncer.Plan(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			return actions
+		}, 30*time.Second, time.Second).Should(HaveLen(3))
+
+		result, err := syncer.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Err()).NotTo(HaveOccurred())
+		Expect(result.Done).To(HaveLen(3))
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "remote.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("remote"))
+
+		node, err := client.MkdirAll(context.Background(), folder.Id, "a")
+		Expect(err).NotTo(HaveOccurred())
+		_, ok, err := client.LookupNode(context.Background(), node.Id, "local.txt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		Expect(os.Remove(filepath.Join(dir, "remote.txt"))).To(Succeed())
+
+		actions, err := syncer.Plan(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(actions).To(HaveLen(1))
+		Expect(actions[0].Type).To(Equal(ActionDeleteRemote))
+		Expect(actions[0].Path).To(Equal("remote.txt"))
+	})
+
+	It("should keep both files on conflict", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("base"), 0644)).To(Succeed())
+
+		syncer := NewSyncer(client, dir, folder.Id, nil)
+		syncer.Options.Conflict = clouddriveclient.ConflictKeepBoth
+
+		_, err := syncer.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+
+		node, ok, err := client.LookupNode(context.Background(), folder.Id, "file.txt")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ok).To(BeTrue())
+
+		Expect(ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("local"), 0644)).To(Succeed())
+		_, err = client.OverwriteNode(context.Background(), node.Id, strings.NewReader("remote"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Eventually(func() ActionType {
+			actions, err := syncer.Plan(context.Background())
+			Expect(err).NotTo(HaveOccurred())
+			if len(actions) != 1 {
+				return ""
+			}
+			return actions[0].Type
+		}, 30*time.Second, time.Second).Should(Equal(ActionKeepBoth))
+
+		result, err := syncer.Run(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Err()).NotTo(HaveOccurred())
+		Expect(result.Done).To(HaveLen(1))
+		Expect(result.Done[0].Conflict).To(BeTrue())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "file.txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("remote"))
+
+		data, err = ioutil.ReadFile(filepath.Join(dir, "file (1).txt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("local"))
+	})
+})
+
+var _ = Describe("Syncer offline", func() {
+	now := time.Now().UTC()
+	earlier := now.Add(-time.Hour)
+
+	file := func(md5 string) *LocalFile {
+		return &LocalFile{Path: "a.txt", Md5: md5, ModTime: now}
+	}
+	dir := func(p string) *LocalFile {
+		return &LocalFile{Path: p, IsDir: true}
+	}
+	node := func(md5 string, modified time.Time) *clouddriveclient.Node {
+		return &clouddriveclient.Node{
+			Id:                "a",
+			Kind:              clouddriveclient.NodeKindFile,
+			Status:            clouddriveclient.NodeStatusAvailable,
+			ModifiedDate:      modified,
+			ContentProperties: clouddriveclient.NodeContentProperties{Md5: md5},
+		}
+	}
+	folder := &clouddriveclient.Node{Id: "f", Kind: clouddriveclient.NodeKindFolder}
+	base := &Entry{Path: "a.txt", Md5: "1", NodeId: "a", ModifiedDate: earlier}
+
+	Describe("decide", func() {
+		It("should compare both sides with the last synced state", func() {
+			cases := []struct {
+				name   string
+				local  *LocalFile
+				remote *clouddriveclient.Node
+				base   *Entry
+				action ActionType
+			}{
+				{"unchanged", file("1"), node("1", earlier), base, ""},
+				{"local changed", file("2"), node("1", earlier), base, ActionUpload},
+				{"remote changed", file("1"), node("2", now), base, ActionDownload},
+				{"remote touched without md5 change", file("1"), node("1", now), base, ""},
+				{"remote without md5 unchanged", file("2"), node("", earlier), base, ActionUpload},
+				{"remote without md5 modified", file("1"), node("", now), base, ActionDownload},
+				{"both changed", file("2"), node("3", now), base, ActionConflict},
+				{"both changed alike", file("3"), node("3", now), base, ""},
+				{"local deleted", nil, node("1", earlier), base, ActionDeleteRemote},
+				{"remote deleted", file("1"), nil, base, ActionDeleteLocal},
+				{"local deleted, remote changed", nil, node("2", now), base, ActionConflict},
+				{"remote deleted, local changed", file("2"), nil, base, ActionConflict},
+				{"new local", file("1"), nil, nil, ActionUpload},
+				{"new remote", nil, node("1", now), nil, ActionDownload},
+				{"new local dir", dir("d"), nil, nil, ActionMkdirRemote},
+				{"new remote dir", nil, folder, nil, ActionMkdirLocal},
+				{"file and dir", file("1"), folder, nil, ActionConflict},
+			}
+
+			s := &Syncer{}
+
+			for _, c := range cases {
+				action, ok := s.decide(c.local, c.remote, c.base)
+				Expect(action).To(Equal(c.action), c.name)
+				Expect(ok).To(Equal(c.action != ""), c.name)
+			}
+		})
+
+		It("should follow the direction", func() {
+			s := &Syncer{Options: Options{Direction: Push}}
+
+			action, _ := s.decide(file("1"), node("2", now), base)
+			Expect(action).To(Equal(ActionUpload))
+			action, _ = s.decide(nil, node("1", earlier), base)
+			Expect(action).To(Equal(ActionDeleteRemote))
+
+			s.Options.Direction = Pull
+
+			action, _ = s.decide(file("2"), node("1", earlier), base)
+			Expect(action).To(Equal(ActionDownload))
+			action, _ = s.decide(file("1"), nil, base)
+			Expect(action).To(Equal(ActionDeleteLocal))
+		})
+	})
+
+	Describe("resolveConflict", func() {
+		It("should apply the conflict policy", func() {
+			local := file("2")
+			remote := node("3", earlier)
+
+			cases := []struct {
+				policy clouddriveclient.ConflictPolicy
+				local  *LocalFile
+				remote *clouddriveclient.Node
+				action ActionType
+			}{
+				{clouddriveclient.ConflictFail, local, remote, ActionConflict},
+				{clouddriveclient.ConflictLocalWins, local, remote, ActionUpload},
+				{clouddriveclient.ConflictRemoteWins, local, remote, ActionDownload},
+				{clouddriveclient.ConflictNewestWins, local, remote, ActionUpload},
+				{clouddriveclient.ConflictNewestWins, local, node("3", now.Add(time.Hour)), ActionDownload},
+				{clouddriveclient.ConflictKeepBoth, local, remote, ActionKeepBoth},
+				{clouddriveclient.ConflictKeepBoth, nil, remote, ActionDownload},
+				{clouddriveclient.ConflictNewestWins, local, nil, ActionUpload},
+				{clouddriveclient.ConflictLocalWins, nil, remote, ActionDeleteRemote},
+				{clouddriveclient.ConflictKeepBoth, local, folder, ActionConflict},
+			}
+
+			for i, c := range cases {
+				s := &Syncer{Options: Options{Conflict: c.policy}}
+				Expect(s.resolveConflict(c.local, c.remote)).To(Equal(c.action), "case %d", i)
+			}
+		})
+	})
+
+	Describe("pruneDeletes", func() {
+		deleteLocal := func(p string, isDir bool) *Action {
+			return &Action{Type: ActionDeleteLocal, Path: p, Local: &LocalFile{Path: p, IsDir: isDir}}
+		}
+		deleteRemote := func(p string, isDir bool) *Action {
+			kind := clouddriveclient.NodeKindFile
+			if isDir {
+				kind = clouddriveclient.NodeKindFolder
+			}
+			return &Action{Type: ActionDeleteRemote, Path: p, Remote: &clouddriveclient.Node{Kind: kind}}
+		}
+		upload := func(p string) *Action {
+			return &Action{Type: ActionUpload, Path: p, Local: &LocalFile{Path: p}}
+		}
+
+		It("should keep a directory deletion only if nothing else happens under it", func() {
+			cases := []struct {
+				name    string
+				actions []*Action
+				kept    []string
+			}{
+				{
+					"whole directory",
+					[]*Action{deleteLocal("d", true), deleteLocal("d/a", false), deleteLocal("d/e", true), deleteLocal("d/e/b", false)},
+					[]string{"d"},
+				},
+				{
+					"upload inside",
+					[]*Action{deleteLocal("d", true), deleteLocal("d/a", false), upload("d/b")},
+					[]string{"d/a", "d/b"},
+				},
+				{
+					"sibling sorted between",
+					[]*Action{deleteLocal("docs", true), deleteLocal("docs.txt", false), upload("docs/new.txt")},
+					[]string{"docs.txt", "docs/new.txt"},
+				},
+				{
+					"other side deletion inside",
+					[]*Action{deleteRemote("d", true), deleteLocal("d/a", false)},
+					[]string{"d/a"},
+				},
+				{
+					"nested",
+					[]*Action{deleteRemote("d", true), deleteRemote("d/e", true), deleteRemote("d/e/a", false), upload("d/f")},
+					[]string{"d/e", "d/f"},
+				},
+			}
+
+			for _, c := range cases {
+				// actions come sorted from plan
+				sort.Slice(c.actions, func(i, j int) bool {
+					return pathLess(c.actions[i].Path, c.actions[j].Path)
+				})
+
+				kept := []string{}
+				for _, a := range pruneDeletes(c.actions) {
+					kept = append(kept, a.Path)
+				}
+
+				sort.Strings(kept)
+				Expect(kept).To(Equal(c.kept), c.name)
+			}
+		})
+
+		It("should sort a directory before its subtree", func() {
+			paths := []string{"docs.txt", "docs/new.txt", "docs", "docs-old", "docs/a/b"}
+
+			sort.Slice(paths, func(i, j int) bool {
+				return pathLess(paths[i], paths[j])
+			})
+
+			Expect(paths).To(Equal([]string{"docs", "docs/a/b", "docs/new.txt", "docs-old", "docs.txt"}))
+		})
+	})
+
+	Describe("deleteLocal", func() {
+		It("should keep ignored files and their directories", func() {
+			localDir, err := ioutil.TempDir("", "clouddrivesync")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(localDir)
+
+			write := func(p string) {
+				full := filepath.Join(localDir, filepath.FromSlash(p))
+				Expect(os.MkdirAll(filepath.Dir(full), 0755)).To(Succeed())
+				Expect(ioutil.WriteFile(full, []byte(p), 0644)).To(Succeed())
+			}
+
+			write("d/a.txt")
+			write("d/e/b.txt")
+			write("d/k/c.txt")
+			write("d/k/keep.tmp")
+			write("d/.clouddrivesync-partial")
+			write("docs.txt")
+
+			s := NewSyncer(nil, localDir, "root", nil)
+			s.Options.Ignore = func(p string, isDir bool) bool {
+				return strings.HasSuffix(p, ".tmp")
+			}
+
+			local, err := s.scanLocal(context.Background(), &State{Entries: map[string]*Entry{}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(local).NotTo(HaveKey("d/k/keep.tmp"))
+
+			Expect(s.deleteLocal(&plan{local: local}, "d")).To(Succeed())
+
+			remaining := []string{}
+			filepath.Walk(localDir, func(p string, info os.FileInfo, err error) error {
+				rel, _ := filepath.Rel(localDir, p)
+				remaining = append(remaining, filepath.ToSlash(rel))
+				return nil
+			})
+
+			Expect(remaining).To(Equal([]string{".", "d", "d/.clouddrivesync-partial", "d/k", "d/k/keep.tmp", "docs.txt"}))
+		})
+	})
+})
@@ -0,0 +1,192 @@
+package clouddriveclient
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens when a file is written to a name that
+// already exists.
+type ConflictPolicy int
+
+const (
+	// ConflictFail returns the NAME_ALREADY_EXISTS error.
+	ConflictFail ConflictPolicy = iota
+	// ConflictKeepBoth writes the new content under a name generated by a
+	// ConflictNamer.
+	ConflictKeepBoth
+	// ConflictNewestWins keeps the content modified last. Local content
+	// without a modification time is considered newest.
+	ConflictNewestWins
+	// ConflictLocalWins overwrites the existing file.
+	ConflictLocalWins
+	// ConflictRemoteWins keeps the existing file.
+	ConflictRemoteWins
+)
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case ConflictFail:
+		return "fail"
+	case ConflictKeepBoth:
+		return "keepBoth"
+	case ConflictNewestWins:
+		return "newestWins"
+	case ConflictLocalWins:
+		return "localWins"
+	case ConflictRemoteWins:
+		return "remoteWins"
+	}
+	return fmt.Sprintf("ConflictPolicy(%d)", int(p))
+}
+
+// ConflictNamer returns the name of the n-th (starting with 1) alternative
+// for name.
+type ConflictNamer func(name string, n int) string
+
+// MaxConflictNames limits how many names ConflictKeepBoth tries.
+const MaxConflictNames = 100
+
+// DefaultConflictName turns "file.txt" into "file (n).txt".
+func DefaultConflictName(name string, n int) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	if base == "" {
+		base, ext = name, ""
+	}
+
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
+
+// LocalWins reports whether local content modified at modTime wins over
+// existing under policy. It is only meaningful for ConflictNewestWins,
+// ConflictLocalWins and ConflictRemoteWins.
+func (p ConflictPolicy) LocalWins(modTime time.Time, existing *Node) bool {
+	switch p {
+	case ConflictLocalWins:
+		return true
+	case ConflictNewestWins:
+		return modTime.IsZero() || modTime.After(existing.ModifiedDate)
+	}
+	return false
+}
+
+func (o *UploadOptions) conflictName(name string, n int) string {
+	if o.ConflictName != nil {
+		return o.ConflictName(name, n)
+	}
+	return DefaultConflictName(name, n)
+}
+
+func isNameConflict(err error) (cde *CloudDriveError, ok bool) {
+	cde, ok = IsCloudDriveError(err)
+	return cde, ok && cde.Code == ErrorCodeNameAlreadyExists
+}
+
+// conflictingNode returns the node that caused a NAME_ALREADY_EXISTS error.
+// An available node is preferred, the conflict may also be caused by a
+// trashed one, see restoreTrashedFile.
+func (d *CloudDrive) conflictingNode(ctx context.Context, parentId string, name string, cde *CloudDriveError) (node *Node, err error) {
+	node, ok, err := d.lookupAvailableNode(ctx, parentId, name)
+	if err != nil || ok {
+		return node, err
+	}
+
+	if nodeId, ok := cde.ConflictingNodeId(); ok {
+		return d.LookupNodeById(ctx, nodeId)
+	}
+
+	node, ok, err = d.LookupNode(ctx, parentId, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, cde
+	}
+
+	return node, nil
+}
+
+// lookupNameHolder returns the node holding name in parentId. Cloud Drive
+// keeps trashed nodes in their parents, so a trashed node holds its name if
+// there is no available one.
+func (d *CloudDrive) lookupNameHolder(ctx context.Context, parentId string, name string) (node *Node, ok bool, err error) {
+	node, ok, err = d.lookupAvailableNode(ctx, parentId, name)
+	if err != nil || ok {
+		return node, ok, err
+	}
+
+	return d.LookupNode(ctx, parentId, name)
+}
+
+// restoreTrashedFile restores node if it is a trashed file holding a name
+// that looks free, so that it can be overwritten in place of creating a new
+// file. conflictErr is returned for other nodes that are not available.
+func (d *CloudDrive) restoreTrashedFile(ctx context.Context, node *Node, conflictErr error) (restored *Node, err error) {
+	if node.Status == NodeStatusAvailable {
+		return node, nil
+	}
+
+	if node.Status != NodeStatusTrash || node.Kind != NodeKindFile {
+		return nil, conflictErr
+	}
+
+	return d.RestoreNode(ctx, node.Id)
+}
+
+// resolveUploadConflict applies opts.Conflict after name in parentId turned
+// out to be taken by existing. conflictErr is returned if the policy can't
+// be applied. If existing is a trashed file, nothing is visible under the
+// name, so it is restored and overwritten whatever the policy.
+func (d *CloudDrive) resolveUploadConflict(ctx context.Context, parentId string, name string, existing *Node, body *uploadBody, opts *UploadOptions, conflictErr error) (node *Node, err error) {
+	if existing.Status != NodeStatusAvailable {
+		restored, err := d.restoreTrashedFile(ctx, existing, conflictErr)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.overwriteNode(ctx, restored.Id, body)
+	}
+
+	if opts.Conflict == ConflictKeepBoth {
+		for n := 1; n <= MaxConflictNames; n++ {
+			altName := opts.conflictName(name, n)
+
+			// content that can only be read once is sent just once, to a
+			// name that looks free
+			if !body.rewindable() {
+				_, exists, err := d.LookupNode(ctx, parentId, altName)
+				if err != nil {
+					return nil, err
+				}
+				if exists {
+					continue
+				}
+			}
+
+			node, err = d.createFile(ctx, parentId, altName, body, opts)
+
+			if _, ok := isNameConflict(err); ok && body.rewindable() {
+				continue
+			}
+
+			return node, err
+		}
+
+		return nil, conflictErr
+	}
+
+	if existing.Kind != NodeKindFile {
+		return nil, conflictErr
+	}
+
+	if !opts.Conflict.LocalWins(opts.ModTime, existing) {
+		return existing, nil
+	}
+
+	return d.overwriteNode(ctx, existing.Id, body)
+}
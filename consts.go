@@ -7,6 +7,7 @@ const (
 	ErrorCodeNameAlreadyExists         = "NAME_ALREADY_EXISTS"
 	ErrorCodeCustomerNotFound          = "CUSTOMER_NOT_FOUND"
 	ErrorCodeTooManyRequests           = "TOO_MANY_REQUESTS"
+	ErrorCodeDuplicate                 = "DUPLICATE"
 )
 
 const (
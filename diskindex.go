@@ -0,0 +1,288 @@
+package clouddriveclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	diskIndexSnapshotFile = "snapshot.json"
+	diskIndexJournalFile  = "journal.json"
+)
+
+// DiskIndexCompactNodes is the number of journaled nodes after which
+// DiskIndex rewrites its snapshot.
+var DiskIndexCompactNodes = 100000
+
+// DiskIndex is a MetadataIndex persisted in directory Dir, so that a
+// restarted process resumes from the last checkpoint instead of reading the
+// whole changes feed again. The index is held in memory (see NodeIndex for
+// lookups and searches). Change sets are appended to a journal which is
+// periodically folded into a snapshot.
+type DiskIndex struct {
+	*NodeIndex
+
+	Dir string
+
+	journal      *os.File
+	journalNodes int
+	fileMutex    sync.Mutex
+}
+
+var _ MetadataIndex = (*DiskIndex)(nil)
+
+type diskIndexSnapshot struct {
+	Checkpoint string  `json:"checkpoint"`
+	Complete   bool    `json:"complete"`
+	Nodes      []*Node `json:"nodes"`
+}
+
+type diskIndexRecord struct {
+	Changes  *Changes `json:"changes,omitempty"`
+	Complete bool     `json:"complete,omitempty"`
+}
+
+// OpenDiskIndex loads the index from dir, creating it if it doesn't exist.
+func OpenDiskIndex(dir string) (index *DiskIndex, err error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	index = &DiskIndex{
+		NodeIndex: NewNodeIndex(),
+		Dir:       dir,
+	}
+
+	if err := index.load(); err != nil {
+		return nil, err
+	}
+
+	index.journal, err = os.OpenFile(index.path(diskIndexJournalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func (i *DiskIndex) path(name string) string {
+	return filepath.Join(i.Dir, name)
+}
+
+func (i *DiskIndex) load() error {
+	data, err := ioutil.ReadFile(i.path(diskIndexSnapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil {
+		snapshot := &diskIndexSnapshot{}
+
+		// the journal only makes sense on top of the snapshot, so with a
+		// damaged snapshot the index starts empty and is rebuilt from the
+		// changes feed
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return i.discard()
+		}
+
+		for _, node := range snapshot.Nodes {
+			i.NodeIndex.put(node)
+		}
+		i.NodeIndex.checkpoint = snapshot.Checkpoint
+		i.NodeIndex.complete = snapshot.Complete
+	}
+
+	journal, err := os.Open(i.path(diskIndexJournalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(journal))
+
+	for decoder.More() {
+		valid := decoder.InputOffset()
+
+		record := &diskIndexRecord{}
+
+		// a record cut short by a crash is the last one and was never
+		// acknowledged, so it is dropped before anything is appended after it
+		if err := decoder.Decode(record); err != nil {
+			return os.Truncate(i.path(diskIndexJournalFile), valid)
+		}
+
+		if err := i.applyRecord(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// discard removes the snapshot and the journal.
+func (i *DiskIndex) discard() error {
+	if err := os.Remove(i.path(diskIndexSnapshotFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Remove(i.path(diskIndexJournalFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (i *DiskIndex) applyRecord(record *diskIndexRecord) error {
+	if record.Changes != nil {
+		i.journalNodes += len(record.Changes.Nodes)
+
+		if err := i.NodeIndex.Apply(record.Changes); err != nil {
+			return err
+		}
+	}
+
+	if record.Complete {
+		return i.NodeIndex.MarkComplete()
+	}
+
+	return nil
+}
+
+func (i *DiskIndex) write(record *diskIndexRecord) error {
+	i.fileMutex.Lock()
+	defer i.fileMutex.Unlock()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := i.journal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if err := i.journal.Sync(); err != nil {
+		return err
+	}
+
+	if err := i.applyRecord(record); err != nil {
+		return err
+	}
+
+	if i.journalNodes >= DiskIndexCompactNodes {
+		return i.compact()
+	}
+
+	return nil
+}
+
+// Apply journals the change set and applies it.
+func (i *DiskIndex) Apply(changes *Changes) error {
+	return i.write(&diskIndexRecord{Changes: changes})
+}
+
+func (i *DiskIndex) MarkComplete() error {
+	return i.write(&diskIndexRecord{Complete: true})
+}
+
+// Compact writes the current state to the snapshot and empties the journal.
+func (i *DiskIndex) Compact() error {
+	i.fileMutex.Lock()
+	defer i.fileMutex.Unlock()
+
+	return i.compact()
+}
+
+func (i *DiskIndex) compact() error {
+	snapshot := &diskIndexSnapshot{
+		Nodes: []*Node{},
+	}
+
+	snapshot.Checkpoint, snapshot.Complete = i.NodeIndex.Checkpoint()
+
+	i.NodeIndex.Nodes(func(node *Node) bool {
+		snapshot.Nodes = append(snapshot.Nodes, node)
+		return true
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp := i.path(diskIndexSnapshotFile + ".tmp")
+
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, i.path(diskIndexSnapshotFile)); err != nil {
+		return err
+	}
+
+	// the snapshot must be on disk before the journal is emptied
+	if err := syncDir(i.Dir); err != nil {
+		return err
+	}
+
+	if err := i.journal.Truncate(0); err != nil {
+		return err
+	}
+
+	i.journalNodes = 0
+
+	return nil
+}
+
+// Close compacts the index and closes the journal.
+func (i *DiskIndex) Close() error {
+	i.fileMutex.Lock()
+	defer i.fileMutex.Unlock()
+
+	if err := i.compact(); err != nil {
+		i.journal.Close()
+		return err
+	}
+
+	return i.journal.Close()
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,214 @@
+package clouddriveclient
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type DuplicateAction int
+
+const (
+	// DuplicateActionTrash trashes all but the first file of a group.
+	DuplicateActionTrash DuplicateAction = iota
+	// DuplicateActionLink trashes all but the first file of a group and adds
+	// the first file to the folders of the trashed ones, so every folder
+	// still contains the content (under the first file's name).
+	DuplicateActionLink
+)
+
+type DuplicateFile struct {
+	Path     string
+	ParentId string
+	Node     *Node
+}
+
+type DuplicateGroup struct {
+	Md5  string
+	Size int64
+	// Files are sorted by path. The first one is kept by ResolveDuplicates.
+	Files       []*DuplicateFile
+	Reclaimable int64
+}
+
+type DuplicateReport struct {
+	// Groups are sorted by reclaimable bytes, largest first.
+	Groups      []*DuplicateGroup
+	Reclaimable int64
+}
+
+// FindDuplicates walks the subtree of rootId and groups available files with
+// the same md5 and size. Files linked into several folders (one node with
+// multiple parents) are not duplicates and are reported once. Trashed
+// folders are not walked.
+func (d *CloudDrive) FindDuplicates(ctx context.Context, rootId string, opts *WalkOptions) (report *DuplicateReport, err error) {
+	// paths of walked folders by node id, sibling folders can have the same
+	// name
+	folders := map[string]string{}
+	seen := map[string]bool{}
+	groups := map[string]*DuplicateGroup{}
+
+	err = d.WalkWithOptions(ctx, rootId, opts, func(p string, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p != "." && node.Status != NodeStatusAvailable {
+			if node.Kind == NodeKindFolder {
+				return SkipDir
+			}
+			return nil
+		}
+
+		if node.Kind == NodeKindFolder {
+			folders[node.Id] = p
+			return nil
+		}
+
+		if node.Kind != NodeKindFile || node.ContentProperties.Md5 == "" {
+			return nil
+		}
+
+		if seen[node.Id] {
+			return nil
+		}
+		seen[node.Id] = true
+
+		key := node.ContentProperties.Md5 + ":" + strconv.FormatInt(node.ContentProperties.Size, 10)
+
+		group, ok := groups[key]
+		if !ok {
+			group = &DuplicateGroup{
+				Md5:   node.ContentProperties.Md5,
+				Size:  node.ContentProperties.Size,
+				Files: []*DuplicateFile{},
+			}
+			groups[key] = group
+		}
+
+		group.Files = append(group.Files, &DuplicateFile{
+			Path:     p,
+			ParentId: walkedParent(folders, path.Dir(p), node),
+			Node:     node,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	report = &DuplicateReport{
+		Groups: []*DuplicateGroup{},
+	}
+
+	for _, group := range groups {
+		if len(group.Files) < 2 {
+			continue
+		}
+
+		sort.Slice(group.Files, func(i, j int) bool {
+			return group.Files[i].Path < group.Files[j].Path
+		})
+
+		group.Reclaimable = group.Size * int64(len(group.Files)-1)
+
+		report.Groups = append(report.Groups, group)
+		report.Reclaimable += group.Reclaimable
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if report.Groups[i].Reclaimable != report.Groups[j].Reclaimable {
+			return report.Groups[i].Reclaimable > report.Groups[j].Reclaimable
+		}
+		return report.Groups[i].Files[0].Path < report.Groups[j].Files[0].Path
+	})
+
+	return report, nil
+}
+
+// walkedParent returns the parent of node the walk found it in.
+func walkedParent(folders map[string]string, dir string, node *Node) string {
+	for _, parentId := range node.Parents {
+		if p, ok := folders[parentId]; ok && p == dir {
+			return parentId
+		}
+	}
+	return ""
+}
+
+// ResolveDuplicates applies action to every group in report, keeping the
+// first file of each group. With DuplicateActionLink a trashed duplicate is
+// restored if linking fails.
+func (d *CloudDrive) ResolveDuplicates(ctx context.Context, report *DuplicateReport, action DuplicateAction, opts *TreeOptions) (result *TreeResult, err error) {
+	op := newTreeOp(opts)
+
+	groups := make(chan *DuplicateGroup)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < op.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for group := range groups {
+				keep := group.Files[0]
+
+				for _, file := range group.Files[1:] {
+					if err := d.resolveDuplicate(ctx, keep, file, action); err != nil {
+						op.fail(file.Path, file.Node.Id, err)
+					} else {
+						op.done(file.Path, file.Node.Id, group.Size)
+					}
+				}
+			}
+		}()
+	}
+
+	for _, group := range report.Groups {
+		if ctx.Err() != nil {
+			break
+		}
+		groups <- group
+	}
+
+	close(groups)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return op.result, nil
+}
+
+func (d *CloudDrive) resolveDuplicate(ctx context.Context, keep *DuplicateFile, file *DuplicateFile, action DuplicateAction) error {
+	if _, err := d.DeleteNode(ctx, file.Node.Id); err != nil {
+		return err
+	}
+
+	if action != DuplicateActionLink || file.ParentId == "" {
+		return nil
+	}
+
+	for _, parentId := range keep.Node.Parents {
+		if parentId == file.ParentId {
+			return nil
+		}
+	}
+
+	if err := d.AddNodeParent(ctx, keep.Node.Id, file.ParentId); err != nil {
+		if _, restoreErr := d.RestoreNode(ctx, file.Node.Id); restoreErr != nil {
+			return fmt.Errorf("%w (restoring %s from trash failed: %w)", err, file.Node.Id, restoreErr)
+		}
+		return err
+	}
+
+	return nil
+}
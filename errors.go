@@ -1,24 +1,49 @@
 package clouddriveclient
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 
 	"github.com/koofr/go-httpclient"
 )
 
 type CloudDriveError struct {
-	Code            string `json:"code"`
-	Message         string `json:"message"`
-	Logref          string `json:"logref"`
+	Code            string               `json:"code"`
+	Message         string               `json:"message"`
+	Logref          string               `json:"logref"`
+	Info            *CloudDriveErrorInfo `json:"info"`
 	HttpClientError *httpclient.InvalidStatusError
 }
 
+type CloudDriveErrorInfo struct {
+	NodeId string `json:"nodeId"`
+}
+
 func (e *CloudDriveError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Code, e.Message)
 }
 
+var conflictingNodeIdRegexp = regexp.MustCompile(`conflicting NodeId: (\S+)`)
+
+// ConflictingNodeId returns the id of the existing node that caused a
+// NAME_ALREADY_EXISTS error. Amazon puts it in info.nodeId, older responses
+// only mention it in the message.
+func (e *CloudDriveError) ConflictingNodeId() (nodeId string, ok bool) {
+	if e.Info != nil && e.Info.NodeId != "" {
+		return e.Info.NodeId, true
+	}
+
+	if m := conflictingNodeIdRegexp.FindStringSubmatch(e.Message); m != nil {
+		return m[1], true
+	}
+
+	return "", false
+}
+
 var ErrCustomerNotFound = &CloudDriveError{
 	Code:            ErrorCodeCustomerNotFound,
 	Message:         "Endpoint customer does not exist",
@@ -33,6 +58,80 @@ var ErrRootNotFound = &CloudDriveError{
 	HttpClientError: nil,
 }
 
+var ErrTempLinkNotFound = &CloudDriveError{
+	Code:            ErrorCodeNodeNotFound,
+	Message:         "Node has no temp link",
+	Logref:          "",
+	HttpClientError: nil,
+}
+
+var ErrNodeChanged = errors.New("node content changed during download")
+
+// ErrRangeIgnored is returned when a ranged download didn't get the
+// requested range back.
+var ErrRangeIgnored = errors.New("server did not return the requested range")
+
+var ErrNotFolder = &CloudDriveError{
+	Code:            ErrorCodeNameAlreadyExists,
+	Message:         "Node with the name already exists and is not a folder",
+	Logref:          "",
+	HttpClientError: nil,
+}
+
+var ErrNotFile = &CloudDriveError{
+	Code:            ErrorCodeNameAlreadyExists,
+	Message:         "Node with the name already exists and is not a file",
+	Logref:          "",
+	HttpClientError: nil,
+}
+
+var ErrNameAlreadyExists = &CloudDriveError{
+	Code:            ErrorCodeNameAlreadyExists,
+	Message:         "Node with the name already exists",
+	Logref:          "",
+	HttpClientError: nil,
+}
+
+// DuplicateError is returned by UploadNodeWithOptions when deduplication is
+// enabled and the uploaded content already exists as node NodeId.
+type DuplicateError struct {
+	NodeId string
+	Err    *CloudDriveError
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("content already exists as node %s: %s", e.NodeId, e.Err)
+}
+
+func IsDuplicateError(err error) (duplicateErr *DuplicateError, ok bool) {
+	if de, ok := err.(*DuplicateError); ok {
+		return de, true
+	} else {
+		return nil, false
+	}
+}
+
+// ChecksumMismatchError is returned when md5 of transferred content differs
+// from the md5 Cloud Drive has for node NodeId. For uploads Expected is the
+// md5 of the sent content, for downloads the md5 of the node.
+type ChecksumMismatchError struct {
+	NodeId   string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for node %s: expected %s, got %s", e.NodeId, e.Expected, e.Actual)
+}
+
+func IsChecksumMismatchError(err error) (checksumErr *ChecksumMismatchError, ok bool) {
+	if cme, ok := err.(*ChecksumMismatchError); ok {
+		return cme, true
+	} else {
+		return nil, false
+	}
+}
+
 func IsCloudDriveError(err error) (cloudDriveErr *CloudDriveError, ok bool) {
 	if cde, ok := err.(*CloudDriveError); ok {
 		return cde, true
@@ -41,6 +140,36 @@ func IsCloudDriveError(err error) (cloudDriveErr *CloudDriveError, ok bool) {
 	}
 }
 
+// isTransientError reports whether an operation that failed with err may
+// succeed if repeated.
+func isTransientError(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	if cde, ok := IsCloudDriveError(err); ok {
+		if cde.HttpClientError == nil {
+			return false
+		}
+		got := cde.HttpClientError.Got
+		return got == http.StatusTooManyRequests || got >= http.StatusInternalServerError
+	}
+
+	if _, ok := IsDuplicateError(err); ok {
+		return false
+	}
+
+	if _, ok := IsChecksumMismatchError(err); ok {
+		return false
+	}
+
+	if err == ErrRangeIgnored || err == ErrNodeChanged {
+		return false
+	}
+
+	return true
+}
+
 func HandleError(err error) error {
 	ise, ok := httpclient.IsInvalidStatusError(err)
 	if !ok {
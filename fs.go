@@ -0,0 +1,273 @@
+package clouddriveclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+var errIsDir = errors.New("is a directory")
+
+// FS exposes the tree under node RootId as a read-only fs.FS. Names are
+// resolved case insensitively, like in Cloud Drive. All requests use the
+// context FS was created with.
+type FS struct {
+	Drive  *CloudDrive
+	RootId string
+
+	ctx context.Context
+}
+
+var (
+	_ fs.FS         = (*FS)(nil)
+	_ fs.ReadDirFS  = (*FS)(nil)
+	_ fs.StatFS     = (*FS)(nil)
+	_ fs.ReadFileFS = (*FS)(nil)
+)
+
+func NewFS(ctx context.Context, d *CloudDrive, rootId string) *FS {
+	return &FS{
+		Drive:  d,
+		RootId: rootId,
+		ctx:    ctx,
+	}
+}
+
+// lookup resolves name to a node. Only available nodes are found.
+func (f *FS) lookup(op string, name string) (node *Node, err error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node, err = f.Drive.LookupNodeById(f.ctx, f.RootId)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	if name == "." {
+		return node, nil
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if node.Kind != NodeKindFolder {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		child, ok, err := f.Drive.lookupAvailableNode(f.ctx, node.Id, part)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+
+		node = child
+	}
+
+	return node, nil
+}
+
+func (f *FS) Open(name string) (fs.File, error) {
+	node, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	info := newNodeFileInfo(name, node)
+
+	if node.Kind == NodeKindFolder {
+		return &fsDir{fs: f, name: name, info: info}, nil
+	}
+
+	reader := &NodeReader{
+		d:    f.Drive,
+		ctx:  f.ctx,
+		node: node,
+	}
+
+	return &fsFile{NodeReader: reader, info: info}, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newNodeFileInfo(name, node), nil
+}
+
+// ReadDir returns the entries of directory name sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.readDir(name, node)
+}
+
+func (f *FS) readDir(name string, node *Node) ([]fs.DirEntry, error) {
+	if node.Kind != NodeKindFolder {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	children, err := f.Drive.NodeChildren(f.ctx, node.Id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+
+	for _, child := range children {
+		if child.Status != NodeStatusAvailable {
+			continue
+		}
+
+		entries = append(entries, fs.FileInfoToDirEntry(&nodeFileInfo{node: child, name: child.Name}))
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Name() < entries[b].Name()
+	})
+
+	return entries, nil
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	node, err := f.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.Kind == NodeKindFolder {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDir}
+	}
+
+	reader, _, err := f.Drive.DownloadNode(f.ctx, node.Id, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return data, nil
+}
+
+// nodeFileInfo is the fs.FileInfo of a node. Sys returns the *Node.
+type nodeFileInfo struct {
+	node *Node
+	name string
+}
+
+// newNodeFileInfo returns the info of node opened as name. The name is the
+// one stored in Cloud Drive, which may differ from name in case.
+func newNodeFileInfo(name string, node *Node) *nodeFileInfo {
+	if name == "." {
+		return &nodeFileInfo{node: node, name: "."}
+	}
+
+	return &nodeFileInfo{node: node, name: node.Name}
+}
+
+func (i *nodeFileInfo) Name() string {
+	return i.name
+}
+
+func (i *nodeFileInfo) Size() int64 {
+	return i.node.ContentProperties.Size
+}
+
+func (i *nodeFileInfo) Mode() fs.FileMode {
+	if i.IsDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *nodeFileInfo) ModTime() time.Time {
+	return i.node.ModifiedDate
+}
+
+func (i *nodeFileInfo) IsDir() bool {
+	return i.node.Kind == NodeKindFolder
+}
+
+func (i *nodeFileInfo) Sys() interface{} {
+	return i.node
+}
+
+// fsFile is an open file. Reads, seeks and ReadAt go through NodeReader.
+type fsFile struct {
+	*NodeReader
+
+	info *nodeFileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// fsDir is an open directory. Its entries are listed on the first ReadDir.
+type fsDir struct {
+	fs      *FS
+	name    string
+	info    *nodeFileInfo
+	entries []fs.DirEntry
+	read    bool
+	offset  int
+}
+
+var _ fs.ReadDirFile = (*fsDir)(nil)
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errIsDir}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.readDir(d.name, d.info.node)
+		if err != nil {
+			return nil, err
+		}
+
+		d.entries = entries
+		d.read = true
+	}
+
+	remaining := d.entries[d.offset:]
+
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	d.offset += n
+
+	return remaining[:n], nil
+}
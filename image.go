@@ -0,0 +1,47 @@
+package clouddriveclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+var imageDateFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000Z",
+	"2006:01:02 15:04:05",
+}
+
+// TakenAt returns the time the image was taken, falling back to its EXIF
+// modification time.
+func (p *ImageProperties) TakenAt() (t time.Time, ok bool) {
+	for _, value := range []string{p.DateTimeOriginal, p.DateTime} {
+		if value == "" {
+			continue
+		}
+
+		for _, format := range imageDateFormats {
+			if t, err := time.Parse(format, value); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Thumbnail downloads a rendition of image node nodeId scaled to fit into
+// width x height, keeping its aspect ratio.
+func (d *CloudDrive) Thumbnail(ctx context.Context, nodeId string, width int, height int) (reader io.ReadCloser, size int64, err error) {
+	params := make(url.Values)
+	params.Set("viewBox", fmt.Sprintf("%dx%d", width, height))
+
+	res, err := d.tempLinkRequest(ctx, nodeId, params, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return res.Body, res.ContentLength, nil
+}
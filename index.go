@@ -0,0 +1,257 @@
+package clouddriveclient
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MetadataIndex stores the node tree built from the changes feed.
+type MetadataIndex interface {
+	Node(nodeId string) (node *Node, ok bool)
+	Child(parentId string, name string) (node *Node, ok bool)
+	Children(parentId string) (nodes []*Node)
+	// Checkpoint returns the checkpoint of the last applied change set and
+	// whether the index reached the end of the feed since its last reset.
+	Checkpoint() (checkpoint string, complete bool)
+	// Apply applies a change set. A change set with Reset clears the index
+	// first.
+	Apply(changes *Changes) error
+	// MarkComplete records that the end of the changes feed was reached.
+	MarkComplete() error
+}
+
+// NodeIndex is an in-memory MetadataIndex.
+type NodeIndex struct {
+	nodes      map[string]*Node
+	children   map[string]map[string]struct{}
+	checkpoint string
+	complete   bool
+	mutex      sync.RWMutex
+}
+
+var _ MetadataIndex = (*NodeIndex)(nil)
+
+func NewNodeIndex() *NodeIndex {
+	return &NodeIndex{
+		nodes:    map[string]*Node{},
+		children: map[string]map[string]struct{}{},
+	}
+}
+
+func (i *NodeIndex) Node(nodeId string) (node *Node, ok bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	node, ok = i.nodes[nodeId]
+	if !ok {
+		return nil, false
+	}
+
+	return copyNode(node), true
+}
+
+// Child finds a child by name. Like Cloud Drive, names are compared case
+// insensitively.
+func (i *NodeIndex) Child(parentId string, name string) (node *Node, ok bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for childId := range i.children[parentId] {
+		child := i.nodes[childId]
+		if strings.EqualFold(child.Name, name) {
+			return copyNode(child), true
+		}
+	}
+
+	return nil, false
+}
+
+// Children returns children of parentId sorted by name.
+func (i *NodeIndex) Children(parentId string) (nodes []*Node) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	nodes = make([]*Node, 0, len(i.children[parentId]))
+
+	for childId := range i.children[parentId] {
+		nodes = append(nodes, copyNode(i.nodes[childId]))
+	}
+
+	sort.Slice(nodes, func(a, b int) bool {
+		return nodes[a].Name < nodes[b].Name
+	})
+
+	return nodes
+}
+
+func (i *NodeIndex) Checkpoint() (checkpoint string, complete bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.checkpoint, i.complete
+}
+
+func (i *NodeIndex) Apply(changes *Changes) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if changes.Reset {
+		i.nodes = map[string]*Node{}
+		i.children = map[string]map[string]struct{}{}
+		i.complete = false
+	}
+
+	for _, node := range changes.Nodes {
+		i.put(node)
+	}
+
+	if changes.Checkpoint != "" {
+		i.checkpoint = changes.Checkpoint
+	}
+
+	return nil
+}
+
+func (i *NodeIndex) MarkComplete() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.complete = true
+
+	return nil
+}
+
+// Nodes calls fn for every node in the index until fn returns false.
+func (i *NodeIndex) Nodes(fn func(node *Node) bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, node := range i.nodes {
+		if !fn(copyNode(node)) {
+			return
+		}
+	}
+}
+
+// put replaces a node and its parent links. Trashed and purged nodes are
+// removed.
+func (i *NodeIndex) put(node *Node) {
+	if old, ok := i.nodes[node.Id]; ok {
+		for _, parentId := range old.Parents {
+			delete(i.children[parentId], node.Id)
+			if len(i.children[parentId]) == 0 {
+				delete(i.children, parentId)
+			}
+		}
+		delete(i.nodes, node.Id)
+	}
+
+	if node.Status == NodeStatusTrash || node.Status == NodeStatusPurged {
+		return
+	}
+
+	node = copyNode(node)
+
+	i.nodes[node.Id] = node
+
+	for _, parentId := range node.Parents {
+		children, ok := i.children[parentId]
+		if !ok {
+			children = map[string]struct{}{}
+			i.children[parentId] = children
+		}
+		children[node.Id] = struct{}{}
+	}
+}
+
+func copyNode(node *Node) *Node {
+	c := *node
+	c.Parents = append([]string(nil), node.Parents...)
+	c.Reader = nil
+	return &c
+}
+
+// Root returns the root folder node.
+func (i *NodeIndex) Root() (node *Node, ok bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, node := range i.nodes {
+		if node.IsRoot {
+			return copyNode(node), true
+		}
+	}
+
+	return nil, false
+}
+
+// LookupPath resolves a slash separated path relative to node parentId.
+func (i *NodeIndex) LookupPath(parentId string, path string) (node *Node, ok bool) {
+	node, ok = i.Node(parentId)
+	if !ok {
+		return nil, false
+	}
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		node, ok = i.Child(node.Id, name)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return node, true
+}
+
+// Path returns the path of node nodeId from the root, following the first
+// parent of each node.
+func (i *NodeIndex) Path(nodeId string) (path string, ok bool) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	names := []string{}
+
+	for {
+		node, ok := i.nodes[nodeId]
+		if !ok {
+			return "", false
+		}
+		if node.IsRoot {
+			break
+		}
+		if len(node.Parents) == 0 || len(names) > len(i.nodes) {
+			return "", false
+		}
+
+		names = append(names, node.Name)
+		nodeId = node.Parents[0]
+	}
+
+	for a, b := 0, len(names)-1; a < b; a, b = a+1, b-1 {
+		names[a], names[b] = names[b], names[a]
+	}
+
+	return "/" + strings.Join(names, "/"), true
+}
+
+// Search returns nodes for which match returns true, sorted by name.
+func (i *NodeIndex) Search(match func(node *Node) bool) (nodes []*Node) {
+	nodes = []*Node{}
+
+	i.Nodes(func(node *Node) bool {
+		if match(node) {
+			nodes = append(nodes, node)
+		}
+		return true
+	})
+
+	sort.Slice(nodes, func(a, b int) bool {
+		return nodes[a].Name < nodes[b].Name
+	})
+
+	return nodes
+}
@@ -0,0 +1,90 @@
+package clouddriveclient
+
+import (
+	"context"
+	"strings"
+)
+
+// MkdirAll creates folder path (slash separated, relative to parentId) along
+// with any missing parent folders and returns the leaf folder. Existing
+// folders are reused, including ones created concurrently by another client.
+func (d *CloudDrive) MkdirAll(ctx context.Context, parentId string, path string) (node *Node, err error) {
+	node, err = d.LookupNodeById(ctx, parentId)
+	if err != nil {
+		return nil, err
+	}
+
+	// once we create a folder its children can't exist, so lookups are skipped
+	// for the rest of the path
+	created := false
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+
+		if !created {
+			existing, ok, err := d.lookupAvailableNode(ctx, node.Id, name)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				if existing.Kind != NodeKindFolder {
+					return nil, ErrNotFolder
+				}
+
+				node = existing
+
+				continue
+			}
+		}
+
+		node, err = d.createOrGetFolder(ctx, node.Id, name)
+		if err != nil {
+			return nil, err
+		}
+
+		created = true
+	}
+
+	return node, nil
+}
+
+func (d *CloudDrive) createOrGetFolder(ctx context.Context, parentId string, name string) (node *Node, err error) {
+	node, err = d.CreateFolder(ctx, parentId, name)
+	if err == nil {
+		return node, nil
+	}
+
+	cde, ok := IsCloudDriveError(err)
+	if !ok || cde.Code != ErrorCodeNameAlreadyExists {
+		return nil, err
+	}
+
+	// somebody else created the node in the meantime
+	if nodeId, ok := cde.ConflictingNodeId(); ok {
+		node, err = d.LookupNodeById(ctx, nodeId)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		node, ok, err = d.lookupAvailableNode(ctx, parentId, name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, cde
+		}
+	}
+
+	if node.Status != NodeStatusAvailable {
+		return nil, cde
+	}
+
+	if node.Kind != NodeKindFolder {
+		return nil, ErrNotFolder
+	}
+
+	return node, nil
+}
@@ -0,0 +1,278 @@
+package clouddriveclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+)
+
+const (
+	DefaultParallelDownloadConcurrency = 4
+	DefaultParallelDownloadChunkSize   = 16 * 1024 * 1024
+)
+
+type ParallelDownloadOptions struct {
+	Concurrency int
+	ChunkSize   int64
+	// MaxRetries per chunk, defaults to CloudDrive.MaxRetries. A retried
+	// chunk continues from the last written byte.
+	MaxRetries int
+	// State of an interrupted download to resume. It is updated as chunks
+	// complete and can be persisted once ParallelDownload returns.
+	State    *ParallelDownloadState
+	Progress ProgressFunc
+}
+
+// ParallelDownloadState records which chunks of a node were already
+// written. It is discarded if the node changed since it was recorded.
+type ParallelDownloadState struct {
+	NodeId    string `json:"nodeId"`
+	Md5       string `json:"md5"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	Done      []bool `json:"done"`
+
+	mutex sync.Mutex
+}
+
+func (s *ParallelDownloadState) matches(node *Node) bool {
+	return s.NodeId == node.Id &&
+		s.Md5 == node.ContentProperties.Md5 &&
+		s.Size == node.ContentProperties.Size &&
+		s.ChunkSize > 0
+}
+
+func (s *ParallelDownloadState) reset(node *Node, chunkSize int64) {
+	s.NodeId = node.Id
+	s.Md5 = node.ContentProperties.Md5
+	s.Size = node.ContentProperties.Size
+	s.ChunkSize = chunkSize
+	s.Done = make([]bool, (s.Size+chunkSize-1)/chunkSize)
+}
+
+func (s *ParallelDownloadState) span(chunk int) *ioutils.FileSpan {
+	start := int64(chunk) * s.ChunkSize
+	end := start + s.ChunkSize - 1
+	if end >= s.Size {
+		end = s.Size - 1
+	}
+	return &ioutils.FileSpan{Start: start, End: end}
+}
+
+func (s *ParallelDownloadState) setDone(chunk int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Done[chunk] = true
+}
+
+// ParallelDownload downloads node nodeId into w, fetching ranges of the
+// content concurrently. Size of the content is taken from node metadata.
+func (d *CloudDrive) ParallelDownload(ctx context.Context, nodeId string, w io.WriterAt, opts *ParallelDownloadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &ParallelDownloadOptions{}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultParallelDownloadConcurrency
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultParallelDownloadChunkSize
+	}
+
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = d.MaxRetries
+	}
+
+	node, err = d.LookupNodeById(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	state := opts.State
+	if state == nil {
+		state = &ParallelDownloadState{}
+	}
+	if !state.matches(node) {
+		state.reset(node, chunkSize)
+	}
+
+	var tracker *progressTracker
+
+	if opts.Progress != nil {
+		tracker = newProgressTracker(state.Size, opts.Progress)
+
+		for chunk, done := range state.Done {
+			if done {
+				span := state.span(chunk)
+				tracker.transferred += span.End - span.Start + 1
+			}
+		}
+	}
+
+	downloadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan int)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for chunk := range chunks {
+				err := d.downloadChunk(downloadCtx, nodeId, w, state.span(chunk), maxRetries, tracker)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+
+				state.setDone(chunk)
+			}
+		}()
+	}
+
+send:
+	for chunk, done := range state.Done {
+		if done {
+			continue
+		}
+
+		select {
+		case chunks <- chunk:
+		case <-downloadCtx.Done():
+			break send
+		}
+	}
+
+	close(chunks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if tracker != nil {
+		tracker.add(0, true)
+	}
+
+	return node, nil
+}
+
+func (d *CloudDrive) downloadChunk(ctx context.Context, nodeId string, w io.WriterAt, span *ioutils.FileSpan, maxRetries int, tracker *progressTracker) error {
+	offset := span.Start
+
+	for retry := 0; ; retry++ {
+		n, readErr, writeErr := d.downloadRange(ctx, nodeId, w, offset, span.End, tracker)
+
+		offset += n
+
+		if writeErr != nil {
+			return writeErr
+		}
+		if readErr == nil {
+			return nil
+		}
+		if !isTransientError(readErr) || ctx.Err() != nil || retry+1 >= maxRetries {
+			return readErr
+		}
+
+		select {
+		case <-time.After(retryDelay(retry)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// checkContentRange verifies that res is the partial content of a range
+// request starting at start. The range may end before end if the content is
+// shorter.
+func checkContentRange(res *http.Response, start int64, end int64) error {
+	if res.StatusCode != http.StatusPartialContent {
+		return ErrRangeIgnored
+	}
+
+	var first, last int64
+
+	if _, err := fmt.Sscanf(res.Header.Get("Content-Range"), "bytes %d-%d/", &first, &last); err != nil {
+		return ErrRangeIgnored
+	}
+
+	if first != start || last > end {
+		return ErrRangeIgnored
+	}
+
+	return nil
+}
+
+func (d *CloudDrive) downloadRange(ctx context.Context, nodeId string, w io.WriterAt, start int64, end int64, tracker *progressTracker) (n int64, readErr error, writeErr error) {
+	res, err := d.downloadRequest(ctx, nodeId, &ioutils.FileSpan{Start: start, End: end})
+	if err != nil {
+		return 0, err, nil
+	}
+	defer res.Body.Close()
+
+	// a full response would be written at the wrong offset
+	if err := checkContentRange(res, start, end); err != nil {
+		return 0, err, nil
+	}
+
+	reader := res.Body
+
+	buf := make([]byte, 32*1024)
+
+	for start+n <= end {
+		nr, err := reader.Read(buf)
+
+		if remaining := end - start - n + 1; int64(nr) > remaining {
+			nr = int(remaining)
+		}
+
+		if nr > 0 {
+			if _, err := w.WriteAt(buf[:nr], start+n); err != nil {
+				return n, nil, err
+			}
+
+			n += int64(nr)
+
+			if tracker != nil {
+				tracker.add(int64(nr), false)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err, nil
+		}
+	}
+
+	if start+n <= end {
+		return n, io.ErrUnexpectedEOF, nil
+	}
+
+	return n, nil, nil
+}
@@ -0,0 +1,112 @@
+package clouddriveclient
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const ProgressInterval = 200 * time.Millisecond
+
+type Progress struct {
+	Transferred int64
+	// Total is -1 if the size is not known.
+	Total int64
+	// Rate is the average transfer rate in bytes per second.
+	Rate float64
+	// ETA is -1 if it can't be estimated.
+	ETA time.Duration
+}
+
+// ProgressFunc receives progress of a transfer at most every
+// ProgressInterval and once at the end. If a transfer is retried from the
+// start, Transferred goes back to 0.
+type ProgressFunc func(progress Progress)
+
+// progressTracker counts transferred bytes, possibly from several
+// goroutines, and reports them to fn.
+type progressTracker struct {
+	fn          ProgressFunc
+	total       int64
+	transferred int64
+	started     time.Time
+	reported    time.Time
+	mutex       sync.Mutex
+}
+
+func newProgressTracker(total int64, fn ProgressFunc) *progressTracker {
+	now := time.Now()
+
+	return &progressTracker{
+		fn:       fn,
+		total:    total,
+		started:  now,
+		reported: now,
+	}
+}
+
+func (t *progressTracker) add(n int64, done bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.transferred += n
+
+	now := time.Now()
+
+	if done || now.Sub(t.reported) >= ProgressInterval {
+		t.reported = now
+		t.fn(t.progress(now))
+	}
+}
+
+func (t *progressTracker) progress(now time.Time) Progress {
+	p := Progress{
+		Transferred: t.transferred,
+		Total:       t.total,
+		ETA:         -1,
+	}
+
+	elapsed := now.Sub(t.started).Seconds()
+	if elapsed > 0 {
+		p.Rate = float64(t.transferred) / elapsed
+	}
+
+	if t.total >= 0 && p.Rate > 0 {
+		remaining := t.total - t.transferred
+		if remaining < 0 {
+			remaining = 0
+		}
+		p.ETA = time.Duration(float64(remaining) / p.Rate * float64(time.Second))
+	}
+
+	return p
+}
+
+type progressReader struct {
+	reader  io.Reader
+	tracker *progressTracker
+}
+
+func newProgressReader(reader io.Reader, total int64, fn ProgressFunc) *progressReader {
+	return &progressReader{
+		reader:  reader,
+		tracker: newProgressTracker(total, fn),
+	}
+}
+
+func (r *progressReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+
+	r.tracker.add(int64(n), err != nil)
+
+	return n, err
+}
+
+type progressReadCloser struct {
+	*progressReader
+	closer io.Closer
+}
+
+func (r *progressReadCloser) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,88 @@
+package clouddriveclient
+
+import (
+	"context"
+	"io"
+)
+
+// maxPutAttempts limits how often PutFile goes back and forth between
+// creating and overwriting when the name keeps changing under it.
+const maxPutAttempts = 3
+
+// PutFile writes size bytes from reader to name in parentId, creating the
+// file or overwriting the existing one. If the existing file already has
+// the same content, nothing is uploaded and it is returned as is. Trashed
+// files don't count as existing, but if one still holds the name it is
+// restored and overwritten.
+func (d *CloudDrive) PutFile(ctx context.Context, parentId string, name string, reader io.ReaderAt, size int64) (node *Node, err error) {
+	return d.PutFileWithOptions(ctx, parentId, name, reader, size, nil)
+}
+
+// PutFileWithOptions is PutFile with upload options. Conflict and
+// SkipIdentical in opts are ignored, Md5 saves hashing the content.
+func (d *CloudDrive) PutFileWithOptions(ctx context.Context, parentId string, name string, reader io.ReaderAt, size int64, opts *UploadOptions) (node *Node, err error) {
+	if opts == nil {
+		opts = &UploadOptions{}
+	}
+
+	body, err := newUploadBodyAt(reader, size)
+	if err != nil {
+		return nil, err
+	}
+
+	body.progress = opts.Progress
+	body.md5 = opts.Md5
+
+	existing, exists, err := d.lookupAvailableNode(ctx, parentId, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		if exists {
+			if existing.Kind != NodeKindFile {
+				return nil, ErrNotFile
+			}
+
+			identical, err := body.identical(existing)
+			if err != nil {
+				return nil, err
+			}
+			if identical {
+				return existing, nil
+			}
+
+			node, err = d.overwriteNode(ctx, existing.Id, body)
+
+			// the file was removed since we looked it up
+			if cde, ok := IsCloudDriveError(err); ok && cde.Code == ErrorCodeNodeNotFound && attempt < maxPutAttempts {
+				exists = false
+				continue
+			}
+
+			return node, err
+		}
+
+		node, err = d.createFile(ctx, parentId, name, body, opts)
+
+		// the file was created since we looked it up
+		if cde, ok := isNameConflict(err); ok && attempt < maxPutAttempts {
+			existing, err = d.conflictingNode(ctx, parentId, name, cde)
+			if err != nil {
+				return nil, err
+			}
+
+			// a trashed file holding the name is overwritten like an
+			// existing one
+			existing, err = d.restoreTrashedFile(ctx, existing, cde)
+			if err != nil {
+				return nil, err
+			}
+
+			exists = true
+			continue
+		}
+
+		return node, err
+	}
+}
@@ -0,0 +1,200 @@
+package clouddriveclient
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ReadAheadSize is the buffer size of NodeReader. Forward seeks within it
+// reuse the open connection instead of issuing a new request.
+const ReadAheadSize = 1024 * 1024
+
+var errNegativeOffset = errors.New("negative offset")
+
+// NodeReader gives random access to the content of a file node using
+// ranged requests. Sequential reads share one response body, ReadAt issues
+// a request per call.
+type NodeReader struct {
+	d    *CloudDrive
+	ctx  context.Context
+	node *Node
+
+	mutex      sync.Mutex
+	offset     int64
+	body       io.ReadCloser
+	buf        *bufio.Reader
+	bodyOffset int64
+}
+
+var _ io.ReadSeekCloser = (*NodeReader)(nil)
+var _ io.ReaderAt = (*NodeReader)(nil)
+
+// OpenReader returns a NodeReader for node nodeId. No content is requested
+// until the first read.
+func (d *CloudDrive) OpenReader(ctx context.Context, nodeId string) (reader *NodeReader, err error) {
+	node, err := d.LookupNodeById(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	reader = &NodeReader{
+		d:    d,
+		ctx:  ctx,
+		node: node,
+	}
+
+	return reader, nil
+}
+
+func (r *NodeReader) Node() *Node {
+	return r.node
+}
+
+func (r *NodeReader) Size() int64 {
+	return r.node.ContentProperties.Size
+}
+
+func (r *NodeReader) Read(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.offset >= r.Size() {
+		return 0, io.EOF
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := r.seekBody(); err != nil {
+		return 0, err
+	}
+
+	n, err = r.buf.Read(p)
+
+	r.offset += int64(n)
+	r.bodyOffset += int64(n)
+
+	if err == io.EOF && r.offset < r.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		r.closeBody()
+	}
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// seekBody makes the open response body positioned at r.offset, skipping
+// forward in the current one if possible.
+func (r *NodeReader) seekBody() error {
+	if r.body != nil {
+		skip := r.offset - r.bodyOffset
+
+		if skip >= 0 && skip <= ReadAheadSize {
+			if _, err := r.buf.Discard(int(skip)); err == nil {
+				r.bodyOffset = r.offset
+				return nil
+			}
+		}
+
+		r.closeBody()
+	}
+
+	body, err := r.d.rangeRequest(r.ctx, r.node.Id, r.offset, r.Size()-1)
+	if err != nil {
+		return err
+	}
+
+	r.body = body
+	r.buf = bufio.NewReaderSize(body, ReadAheadSize)
+	r.bodyOffset = r.offset
+
+	return nil
+}
+
+func (r *NodeReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+		r.buf = nil
+	}
+}
+
+func (r *NodeReader) Seek(offset int64, whence int) (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+// ReadAt reads len(p) bytes at off with a separate ranged request. It does
+// not change the offset used by Read.
+func (r *NodeReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+
+	size := r.Size()
+
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	end := off + int64(len(p)) - 1
+	if end >= size {
+		end = size - 1
+	}
+
+	body, err := r.d.rangeRequest(r.ctx, r.node.Id, off, end)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err = io.ReadFull(body, p[:end-off+1])
+	if err != nil {
+		return n, err
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (r *NodeReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closeBody()
+
+	return nil
+}
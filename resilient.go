@@ -0,0 +1,173 @@
+package clouddriveclient
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/koofr/go-ioutils"
+)
+
+type ResilientDownloadOptions struct {
+	Span *ioutils.FileSpan
+	// MaxRetries is the number of times a broken download is resumed,
+	// defaults to CloudDrive.MaxRetries.
+	MaxRetries int
+}
+
+type resilientReader struct {
+	d          *CloudDrive
+	ctx        context.Context
+	node       *Node
+	body       io.ReadCloser
+	offset     int64
+	end        int64
+	retries    int
+	maxRetries int
+}
+
+// DownloadNodeResilient is like DownloadNode, but if reading the content
+// fails midway, the download transparently continues with a Range request
+// from the last received byte. If the node was changed in between, reading
+// fails with ErrNodeChanged.
+func (d *CloudDrive) DownloadNodeResilient(ctx context.Context, nodeId string, opts *ResilientDownloadOptions) (reader io.ReadCloser, size int64, err error) {
+	if opts == nil {
+		opts = &ResilientDownloadOptions{}
+	}
+
+	maxRetries := opts.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = d.MaxRetries
+	}
+
+	node, err := d.LookupNodeById(ctx, nodeId)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start, end := int64(0), node.ContentProperties.Size-1
+	if opts.Span != nil {
+		start, end = opts.Span.Start, opts.Span.End
+	}
+
+	// a span past the end would never be read completely
+	if end > node.ContentProperties.Size-1 {
+		end = node.ContentProperties.Size - 1
+	}
+
+	if end < start {
+		return d.DownloadNode(ctx, nodeId, opts.Span)
+	}
+
+	body, err := d.rangeRequest(ctx, nodeId, start, end)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader = &resilientReader{
+		d:          d,
+		ctx:        ctx,
+		node:       node,
+		body:       body,
+		offset:     start,
+		end:        end,
+		maxRetries: maxRetries,
+	}
+
+	return reader, end - start + 1, nil
+}
+
+func (r *resilientReader) Read(p []byte) (n int, err error) {
+	for {
+		if r.body == nil {
+			return 0, io.ErrClosedPipe
+		}
+
+		n, err = r.body.Read(p)
+
+		r.offset += int64(n)
+
+		if err == nil || (err == io.EOF && r.offset > r.end) {
+			return n, err
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if !isTransientError(err) || r.ctx.Err() != nil || r.retries >= r.maxRetries {
+			return n, err
+		}
+
+		if resumeErr := r.resume(); resumeErr != nil {
+			return n, resumeErr
+		}
+
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume reopens the content at the current offset, retrying transient
+// failures until maxRetries is reached.
+func (r *resilientReader) resume() error {
+	r.body.Close()
+	r.body = nil
+
+	for {
+		select {
+		case <-time.After(retryDelay(r.retries)):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+
+		r.retries++
+
+		err := r.reopen()
+		if err == nil {
+			return nil
+		}
+
+		if !isTransientError(err) || r.ctx.Err() != nil || r.retries >= r.maxRetries {
+			return err
+		}
+	}
+}
+
+func (r *resilientReader) reopen() error {
+	node, err := r.d.LookupNodeById(r.ctx, r.node.Id)
+	if err != nil {
+		return err
+	}
+
+	// version also changes on renames, so it is only used without md5
+	changed := node.ContentProperties.Md5 != r.node.ContentProperties.Md5 ||
+		node.ContentProperties.Size != r.node.ContentProperties.Size ||
+		(node.ContentProperties.Md5 == "" && node.Version != r.node.Version)
+
+	if changed {
+		return ErrNodeChanged
+	}
+
+	// content from any other offset would be spliced in at r.offset
+	body, err := r.d.rangeRequest(r.ctx, r.node.Id, r.offset, r.end)
+	if err != nil {
+		return err
+	}
+
+	r.body = body
+
+	return nil
+}
+
+func (r *resilientReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	err := r.body.Close()
+	r.body = nil
+
+	return err
+}
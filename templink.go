@@ -0,0 +1,132 @@
+package clouddriveclient
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// DefaultTempLinkTTL is how long a tempLink is reused. Amazon doesn't
+// return the expiry of temp links, so this is kept well below their
+// lifetime.
+const DefaultTempLinkTTL = 5 * time.Minute
+
+// maxTempLinkCacheSize is the number of cached links after which expired
+// ones are pruned.
+const maxTempLinkCacheSize = 1000
+
+// TempLink is a URL that downloads node content without authentication,
+// e.g. in a browser, until ExpiresAt.
+type TempLink struct {
+	NodeId    string
+	URL       string
+	ExpiresAt time.Time
+}
+
+func (l *TempLink) valid() bool {
+	return time.Now().Before(l.ExpiresAt)
+}
+
+// TempLink returns a temporary download URL for node nodeId, reusing a
+// cached one while it is valid.
+func (d *CloudDrive) TempLink(ctx context.Context, nodeId string) (link *TempLink, err error) {
+	link, _, err = d.tempLink(ctx, nodeId)
+	return link, err
+}
+
+func (d *CloudDrive) tempLink(ctx context.Context, nodeId string) (link *TempLink, cached bool, err error) {
+	if link, ok := d.cachedTempLink(nodeId); ok {
+		return link, true, nil
+	}
+
+	node, err := d.LookupNodeById(ctx, nodeId)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if node.TempLink == "" {
+		return nil, false, ErrTempLinkNotFound
+	}
+
+	link = d.cacheTempLink(node)
+
+	return link, false, nil
+}
+
+// InvalidateTempLink drops the cached temp link of node nodeId.
+func (d *CloudDrive) InvalidateTempLink(nodeId string) {
+	d.tempLinkCacheMutex.Lock()
+	defer d.tempLinkCacheMutex.Unlock()
+
+	delete(d.tempLinkCache, nodeId)
+}
+
+func (d *CloudDrive) cachedTempLink(nodeId string) (link *TempLink, ok bool) {
+	d.tempLinkCacheMutex.Lock()
+	defer d.tempLinkCacheMutex.Unlock()
+
+	link, ok = d.tempLinkCache[nodeId]
+	if !ok {
+		return nil, false
+	}
+
+	if !link.valid() {
+		delete(d.tempLinkCache, nodeId)
+		return nil, false
+	}
+
+	return link, true
+}
+
+func (d *CloudDrive) cacheTempLink(node *Node) (link *TempLink) {
+	if node.TempLink == "" {
+		return nil
+	}
+
+	// links are reported with the default lifetime even if caching is
+	// disabled
+	ttl := d.TempLinkTTL
+	if ttl <= 0 {
+		ttl = DefaultTempLinkTTL
+	}
+
+	link = &TempLink{
+		NodeId:    node.Id,
+		URL:       node.TempLink,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	if d.TempLinkTTL <= 0 {
+		return link
+	}
+
+	d.tempLinkCacheMutex.Lock()
+	defer d.tempLinkCacheMutex.Unlock()
+
+	if d.tempLinkCache == nil {
+		d.tempLinkCache = map[string]*TempLink{}
+	}
+
+	if len(d.tempLinkCache) >= maxTempLinkCacheSize {
+		for nodeId, l := range d.tempLinkCache {
+			if !l.valid() {
+				delete(d.tempLinkCache, nodeId)
+			}
+		}
+	}
+
+	d.tempLinkCache[node.Id] = link
+
+	return link
+}
+
+func isExpiredTempLinkError(err error) bool {
+	cde, ok := IsCloudDriveError(err)
+	if !ok || cde.HttpClientError == nil {
+		return false
+	}
+
+	got := cde.HttpClientError.Got
+
+	return got == http.StatusForbidden || got == http.StatusNotFound || got == http.StatusGone
+}
@@ -0,0 +1,357 @@
+package clouddriveclient
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+)
+
+const DefaultTreeConcurrency = 4
+
+type TreeOptions struct {
+	// Concurrency is the number of nodes copied or trashed in parallel.
+	Concurrency int
+	// Link makes CopyTree add the destination folder as an additional parent
+	// of each file instead of downloading and uploading its content.
+	Link bool
+	// OnProgress is called after every processed node. Calls are serialized.
+	OnProgress func(progress TreeProgress)
+}
+
+type TreeProgress struct {
+	Path   string
+	NodeId string
+	Done   int
+	Failed int
+	Bytes  int64
+}
+
+type TreeFailure struct {
+	Path   string
+	NodeId string
+	Err    error
+}
+
+type TreeResult struct {
+	Done     int
+	Bytes    int64
+	Failures []*TreeFailure
+}
+
+// Err returns a *TreeError if any node failed.
+func (r *TreeResult) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	return &TreeError{Failures: r.Failures}
+}
+
+type TreeError struct {
+	Failures []*TreeFailure
+}
+
+func (e *TreeError) Error() string {
+	first := e.Failures[0]
+
+	name := first.Path
+	if name == "" {
+		name = first.NodeId
+	}
+
+	if len(e.Failures) == 1 {
+		return fmt.Sprintf("%s: %s", name, first.Err)
+	}
+	return fmt.Sprintf("%s: %s (and %d more failures)", name, first.Err, len(e.Failures)-1)
+}
+
+type treeOp struct {
+	opts   *TreeOptions
+	mutex  sync.Mutex
+	result *TreeResult
+}
+
+func newTreeOp(opts *TreeOptions) *treeOp {
+	if opts == nil {
+		opts = &TreeOptions{}
+	}
+
+	return &treeOp{
+		opts:   opts,
+		result: &TreeResult{Failures: []*TreeFailure{}},
+	}
+}
+
+func (o *treeOp) concurrency() int {
+	if o.opts.Concurrency <= 0 {
+		return DefaultTreeConcurrency
+	}
+	return o.opts.Concurrency
+}
+
+func (o *treeOp) done(p string, nodeId string, bytes int64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.result.Done++
+	o.result.Bytes += bytes
+
+	o.progress(p, nodeId)
+}
+
+func (o *treeOp) fail(p string, nodeId string, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.result.Failures = append(o.result.Failures, &TreeFailure{
+		Path:   p,
+		NodeId: nodeId,
+		Err:    err,
+	})
+
+	o.progress(p, nodeId)
+}
+
+func (o *treeOp) progress(p string, nodeId string) {
+	if o.opts.OnProgress != nil {
+		o.opts.OnProgress(TreeProgress{
+			Path:   p,
+			NodeId: nodeId,
+			Done:   o.result.Done,
+			Failed: len(o.result.Failures),
+			Bytes:  o.result.Bytes,
+		})
+	}
+}
+
+// CopyTree copies node nodeId with its whole subtree into folder toParentId.
+// Existing destination folders are merged into. Failed nodes are reported in
+// the result and don't stop the copy; err is only returned if the copy could
+// not run at all.
+func (d *CloudDrive) CopyTree(ctx context.Context, nodeId string, toParentId string, opts *TreeOptions) (result *TreeResult, err error) {
+	op := newTreeOp(opts)
+
+	type copyJob struct {
+		path     string
+		node     *Node
+		parentId string
+	}
+
+	jobs := make(chan *copyJob)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < op.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for job := range jobs {
+				bytes, err := d.copyFile(ctx, job.node, job.parentId, op.opts.Link)
+				if err != nil {
+					op.fail(job.path, job.node.Id, err)
+				} else {
+					op.done(job.path, job.node.Id, bytes)
+				}
+			}
+		}()
+	}
+
+	// destination folder ids by source path
+	folders := map[string]string{}
+	// folders we created, so that copying into own subtree terminates
+	created := map[string]bool{}
+
+	err = d.Walk(ctx, nodeId, func(p string, node *Node, err error) error {
+		if err != nil {
+			if p == "." && node == nil {
+				return err
+			}
+			op.fail(p, node.Id, err)
+			return nil
+		}
+
+		if created[node.Id] {
+			return SkipDir
+		}
+
+		if p != "." && (node.Status == NodeStatusTrash || node.Status == NodeStatusPurged) {
+			if node.Kind == NodeKindFolder {
+				return SkipDir
+			}
+			return nil
+		}
+
+		parentId := toParentId
+		if p != "." {
+			parentId = folders[path.Dir(p)]
+		}
+
+		if node.Kind != NodeKindFolder {
+			select {
+			case jobs <- &copyJob{path: p, node: node, parentId: parentId}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		folder, err := d.createOrGetFolder(ctx, parentId, node.Name)
+		if err != nil {
+			op.fail(p, node.Id, err)
+			return SkipDir
+		}
+
+		folders[p] = folder.Id
+		created[folder.Id] = true
+
+		op.done(p, node.Id, 0)
+
+		return nil
+	})
+
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return op.result, nil
+}
+
+func (d *CloudDrive) copyFile(ctx context.Context, node *Node, parentId string, link bool) (bytes int64, err error) {
+	if link {
+		return 0, d.AddNodeParent(ctx, node.Id, parentId)
+	}
+
+	reader, _, err := d.DownloadNode(ctx, node.Id, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	newNode, err := d.UploadNode(ctx, parentId, node.Name, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	return newNode.ContentProperties.Size, nil
+}
+
+// MoveTree moves node nodeId from fromParentId to toParentId. If a folder
+// with the same name already exists in the destination, the two folders are
+// merged recursively and the emptied source folder is trashed.
+func (d *CloudDrive) MoveTree(ctx context.Context, nodeId string, fromParentId string, toParentId string, opts *TreeOptions) (result *TreeResult, err error) {
+	node, err := d.LookupNodeById(ctx, nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	op := newTreeOp(opts)
+
+	d.moveTree(ctx, op, node.Name, node, fromParentId, toParentId)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return op.result, nil
+}
+
+func (d *CloudDrive) moveTree(ctx context.Context, op *treeOp, p string, node *Node, fromParentId string, toParentId string) (ok bool) {
+	_, err := d.MoveNode(ctx, node.Id, fromParentId, toParentId)
+	if err == nil {
+		op.done(p, node.Id, 0)
+		return true
+	}
+
+	cde, isCde := IsCloudDriveError(err)
+	if !isCde || cde.Code != ErrorCodeNameAlreadyExists || node.Kind != NodeKindFolder {
+		op.fail(p, node.Id, err)
+		return false
+	}
+
+	existing, err := d.createOrGetFolder(ctx, toParentId, node.Name)
+	if err != nil {
+		op.fail(p, node.Id, err)
+		return false
+	}
+
+	children, err := d.NodeChildren(ctx, node.Id)
+	if err != nil {
+		op.fail(p, node.Id, err)
+		return false
+	}
+
+	ok = true
+
+	for _, child := range children {
+		if ctx.Err() != nil {
+			return false
+		}
+		if child.Status == NodeStatusTrash || child.Status == NodeStatusPurged {
+			continue
+		}
+		if !d.moveTree(ctx, op, path.Join(p, child.Name), child, node.Id, existing.Id) {
+			ok = false
+		}
+	}
+
+	if !ok {
+		return false
+	}
+
+	if _, err := d.DeleteNode(ctx, node.Id); err != nil {
+		op.fail(p, node.Id, err)
+		return false
+	}
+
+	op.done(p, node.Id, 0)
+
+	return true
+}
+
+// TrashTree moves nodes to trash in parallel. Trashing a folder trashes its
+// whole subtree. Nodes are given by id only, so Path of progress and
+// failures is empty.
+func (d *CloudDrive) TrashTree(ctx context.Context, nodeIds []string, opts *TreeOptions) (result *TreeResult, err error) {
+	op := newTreeOp(opts)
+
+	ids := make(chan string)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < op.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for nodeId := range ids {
+				if _, err := d.DeleteNode(ctx, nodeId); err != nil {
+					op.fail("", nodeId, err)
+				} else {
+					op.done("", nodeId, 0)
+				}
+			}
+		}()
+	}
+
+	for _, nodeId := range nodeIds {
+		if ctx.Err() != nil {
+			break
+		}
+		ids <- nodeId
+	}
+
+	close(ids)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return op.result, nil
+}
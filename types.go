@@ -3,6 +3,8 @@ package clouddriveclient
 import (
 	"io"
 	"time"
+
+	"github.com/koofr/go-ioutils"
 )
 
 type Endpoint struct {
@@ -16,7 +18,9 @@ type Node struct {
 	Name              string                `json:"name"`
 	Kind              string                `json:"kind"`
 	Parents           []string              `json:"parents"`
+	IsRoot            bool                  `json:"isRoot"`
 	Status            string                `json:"status"`
+	Version           int64                 `json:"version"`
 	ModifiedDate      time.Time             `json:"modifiedDate"`
 	ContentProperties NodeContentProperties `json:"contentProperties"`
 	TempLink          string                `json:"tempLink"`
@@ -24,9 +28,22 @@ type Node struct {
 }
 
 type NodeContentProperties struct {
-	Size        int64  `json:"size"`
-	ContentType string `json:"contentType"`
-	Md5         string `json:"md5"`
+	Size        int64            `json:"size"`
+	ContentType string           `json:"contentType"`
+	Md5         string           `json:"md5"`
+	Image       *ImageProperties `json:"image"`
+}
+
+// ImageProperties are set for images Cloud Drive extracted metadata from.
+// Dates are EXIF values as returned by Cloud Drive, see TakenAt.
+type ImageProperties struct {
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	Make             string `json:"make"`
+	Model            string `json:"model"`
+	Orientation      string `json:"orientation"`
+	DateTimeOriginal string `json:"dateTimeOriginal"`
+	DateTime         string `json:"dateTime"`
 }
 
 type Nodes struct {
@@ -41,6 +58,39 @@ type NodeCreate struct {
 	Parents []string `json:"parents"`
 }
 
+type UploadOptions struct {
+	// Deduplication lets Cloud Drive refuse the upload with a *DuplicateError
+	// if the same content already exists in the drive.
+	Deduplication bool
+	// Progress is called while the content is being sent.
+	Progress ProgressFunc
+	// Conflict is applied when the name is already taken. Content given as
+	// io.Reader can't be sent twice, so for it the name is looked up before
+	// uploading.
+	Conflict     ConflictPolicy
+	ConflictName ConflictNamer
+	// ModTime of the content for ConflictNewestWins.
+	ModTime time.Time
+	// SkipIdentical returns the existing file instead of uploading if it
+	// has the same md5 and size.
+	SkipIdentical bool
+	// Md5 of the content, if known. Otherwise it is computed for content
+	// given as io.ReaderAt, while content given as io.Reader is always
+	// uploaded.
+	Md5 string
+}
+
+type DownloadOptions struct {
+	Span *ioutils.FileSpan
+	// Progress is called while the content is being read. Total is the size
+	// of the requested span.
+	Progress ProgressFunc
+	// Md5 is the expected md5 of the whole content (e.g. from
+	// ContentProperties). If set, reading the last byte fails with
+	// *ChecksumMismatchError if the content doesn't match. Ignored with Span.
+	Md5 string
+}
+
 type NodeRename struct {
 	Name string `json:"name"`
 }
@@ -60,4 +110,22 @@ type Changes struct {
 	Checkpoint string  `json:"checkpoint"`
 	Nodes      []*Node `json:"nodes"`
 	Reset      bool    `json:"reset"`
+	End        bool    `json:"end"`
+}
+
+type ChangesOptions struct {
+	Checkpoint string
+	// IncludePurged also returns nodes purged from trash.
+	IncludePurged bool
+	// ChunkSize is the number of nodes per change set.
+	ChunkSize int
+	// MaxNodes limits the number of nodes returned by one request.
+	MaxNodes int
+}
+
+type changesRequest struct {
+	Checkpoint    string `json:"checkpoint,omitempty"`
+	IncludePurged string `json:"includePurged,omitempty"`
+	ChunkSize     int    `json:"chunkSize,omitempty"`
+	MaxNodes      int    `json:"maxNodes,omitempty"`
 }
@@ -0,0 +1,130 @@
+package clouddriveclient
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/koofr/go-httpclient"
+)
+
+// uploadBody is the content of an upload. Content given as io.ReaderAt can
+// be read again for every attempt.
+type uploadBody struct {
+	reader   io.Reader
+	readerAt io.ReaderAt
+	size     int64
+	progress ProgressFunc
+	// md5 of the content if known in advance
+	md5 string
+
+	// hash of the latest attempt
+	hash *hashingReader
+}
+
+func newUploadBodyAt(reader io.ReaderAt, size int64) (body *uploadBody, err error) {
+	if size < 0 {
+		f, ok := reader.(*os.File)
+		if !ok {
+			return nil, fmt.Errorf("unknown upload size")
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+
+		size = info.Size()
+	}
+
+	return &uploadBody{readerAt: reader, size: size}, nil
+}
+
+func (b *uploadBody) rewindable() bool {
+	return b.readerAt != nil
+}
+
+func (b *uploadBody) open() io.Reader {
+	reader := b.reader
+	size := int64(-1)
+
+	if b.readerAt != nil {
+		reader = io.NewSectionReader(b.readerAt, 0, b.size)
+		size = b.size
+	}
+
+	// every attempt gets a new progress reader, so a retry starts from 0
+	if b.progress != nil {
+		reader = newProgressReader(reader, size, b.progress)
+	}
+
+	b.hash = newHashingReader(reader)
+
+	return b.hash
+}
+
+// contentMd5 returns md5 of the content, hashing it without sending it if
+// it wasn't given. ok is false if the content can only be read once.
+func (b *uploadBody) contentMd5() (md5Hex string, ok bool, err error) {
+	if b.md5 != "" {
+		return b.md5, true, nil
+	}
+
+	if !b.rewindable() {
+		return "", false, nil
+	}
+
+	hash := md5.New()
+
+	if _, err := io.Copy(hash, io.NewSectionReader(b.readerAt, 0, b.size)); err != nil {
+		return "", false, err
+	}
+
+	b.md5 = hex.EncodeToString(hash.Sum(nil))
+
+	return b.md5, true, nil
+}
+
+// identical reports whether node is an available file with the same
+// content. Size is only compared if it is known.
+func (b *uploadBody) identical(node *Node) (ok bool, err error) {
+	if node.Kind != NodeKindFile || node.Status != NodeStatusAvailable || node.ContentProperties.Md5 == "" {
+		return false, nil
+	}
+
+	if b.rewindable() && node.ContentProperties.Size != b.size {
+		return false, nil
+	}
+
+	md5Hex, known, err := b.contentMd5()
+	if err != nil || !known {
+		return false, err
+	}
+
+	return md5Hex == node.ContentProperties.Md5, nil
+}
+
+// verify checks the uploaded content against the md5 Cloud Drive computed.
+func (b *uploadBody) verify(node *Node) error {
+	if node.ContentProperties.Md5 == "" {
+		return nil
+	}
+
+	expected := b.hash.md5()
+
+	if node.ContentProperties.Md5 != expected {
+		return &ChecksumMismatchError{
+			NodeId:   node.Id,
+			Expected: expected,
+			Actual:   node.ContentProperties.Md5,
+		}
+	}
+
+	return nil
+}
+
+func (b *uploadBody) attach(req *httpclient.RequestData, extra map[string]string) error {
+	return req.UploadFileExtra("file", "file", b.open(), extra)
+}
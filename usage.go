@@ -0,0 +1,236 @@
+package clouddriveclient
+
+import (
+	"context"
+	"path"
+	"sort"
+	"time"
+)
+
+const DefaultDiskUsageCacheTTL = 5 * time.Minute
+
+type DiskUsageOptions struct {
+	// CountLinksOnce counts a file that appears in several folders of the
+	// subtree (multiple parents) only once in each total.
+	CountLinksOnce bool
+	// NoCache ignores cached results and recomputes the usage.
+	NoCache bool
+	// Walk options used while computing the usage.
+	Walk *WalkOptions
+}
+
+type DiskUsage struct {
+	NodeId  string
+	Size    int64
+	Files   int
+	Folders int
+	// Children are the direct children of the node, largest first.
+	Children []*DiskUsageEntry
+}
+
+type DiskUsageEntry struct {
+	Node    *Node
+	Size    int64
+	Files   int
+	Folders int
+}
+
+type diskUsageCacheKey struct {
+	nodeId         string
+	countLinksOnce bool
+}
+
+type diskUsageCacheEntry struct {
+	usage     *DiskUsage
+	expiresAt time.Time
+}
+
+type folderUsage struct {
+	node     *Node
+	parent   *folderUsage
+	size     int64
+	files    int
+	folders  int
+	children []*folderUsageChild
+}
+
+type folderUsageChild struct {
+	node   *Node
+	folder *folderUsage
+}
+
+// DiskUsage sums content sizes of all files in the subtree of nodeId.
+// Trashed nodes are not counted. Usage of every folder in the subtree is
+// cached for DiskUsageCacheTTL, so querying a subfolder afterwards doesn't
+// hit the API.
+func (d *CloudDrive) DiskUsage(ctx context.Context, nodeId string, opts *DiskUsageOptions) (usage *DiskUsage, err error) {
+	if opts == nil {
+		opts = &DiskUsageOptions{}
+	}
+
+	key := diskUsageCacheKey{nodeId: nodeId, countLinksOnce: opts.CountLinksOnce}
+
+	if !opts.NoCache {
+		if usage, ok := d.cachedDiskUsage(key); ok {
+			return usage, nil
+		}
+	}
+
+	folders := map[string]*folderUsage{}
+	seen := map[string]bool{}
+
+	var root *folderUsage
+
+	err = d.WalkWithOptions(ctx, nodeId, opts.Walk, func(p string, node *Node, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == "." {
+			root = &folderUsage{node: node}
+			if node.Kind == NodeKindFolder {
+				folders[p] = root
+			} else {
+				root.size = node.ContentProperties.Size
+				root.files = 1
+			}
+			return nil
+		}
+
+		if node.Status == NodeStatusTrash || node.Status == NodeStatusPurged {
+			if node.Kind == NodeKindFolder {
+				return SkipDir
+			}
+			return nil
+		}
+
+		parent := folders[path.Dir(p)]
+
+		if node.Kind == NodeKindFolder {
+			folder := &folderUsage{node: node, parent: parent}
+			folders[p] = folder
+			parent.children = append(parent.children, &folderUsageChild{node: node, folder: folder})
+
+			for f := parent; f != nil; f = f.parent {
+				f.folders++
+			}
+
+			return nil
+		}
+
+		parent.children = append(parent.children, &folderUsageChild{node: node})
+
+		for f := parent; f != nil; f = f.parent {
+			if opts.CountLinksOnce && len(node.Parents) > 1 {
+				linkKey := f.node.Id + "/" + node.Id
+				if seen[linkKey] {
+					continue
+				}
+				seen[linkKey] = true
+			}
+
+			f.size += node.ContentProperties.Size
+			f.files++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	usage = root.diskUsage()
+
+	d.cacheDiskUsage(opts.CountLinksOnce, root)
+
+	return usage, nil
+}
+
+// InvalidateDiskUsage drops all cached DiskUsage results.
+func (d *CloudDrive) InvalidateDiskUsage() {
+	d.diskUsageCacheMutex.Lock()
+	defer d.diskUsageCacheMutex.Unlock()
+
+	d.diskUsageCache = nil
+}
+
+func (d *CloudDrive) cachedDiskUsage(key diskUsageCacheKey) (usage *DiskUsage, ok bool) {
+	d.diskUsageCacheMutex.Lock()
+	defer d.diskUsageCacheMutex.Unlock()
+
+	entry, ok := d.diskUsageCache[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		delete(d.diskUsageCache, key)
+		return nil, false
+	}
+
+	return entry.usage, true
+}
+
+func (d *CloudDrive) cacheDiskUsage(countLinksOnce bool, root *folderUsage) {
+	if d.DiskUsageCacheTTL <= 0 {
+		return
+	}
+
+	d.diskUsageCacheMutex.Lock()
+	defer d.diskUsageCacheMutex.Unlock()
+
+	if d.diskUsageCache == nil {
+		d.diskUsageCache = map[diskUsageCacheKey]*diskUsageCacheEntry{}
+	}
+
+	expiresAt := time.Now().Add(d.DiskUsageCacheTTL)
+
+	var add func(f *folderUsage)
+	add = func(f *folderUsage) {
+		key := diskUsageCacheKey{nodeId: f.node.Id, countLinksOnce: countLinksOnce}
+		d.diskUsageCache[key] = &diskUsageCacheEntry{
+			usage:     f.diskUsage(),
+			expiresAt: expiresAt,
+		}
+
+		for _, child := range f.children {
+			if child.folder != nil {
+				add(child.folder)
+			}
+		}
+	}
+
+	add(root)
+}
+
+func (f *folderUsage) diskUsage() *DiskUsage {
+	usage := &DiskUsage{
+		NodeId:   f.node.Id,
+		Size:     f.size,
+		Files:    f.files,
+		Folders:  f.folders,
+		Children: make([]*DiskUsageEntry, 0, len(f.children)),
+	}
+
+	for _, child := range f.children {
+		entry := &DiskUsageEntry{Node: child.node}
+
+		if child.folder != nil {
+			entry.Size = child.folder.size
+			entry.Files = child.folder.files
+			entry.Folders = child.folder.folders
+		} else {
+			entry.Size = child.node.ContentProperties.Size
+			entry.Files = 1
+		}
+
+		usage.Children = append(usage.Children, entry)
+	}
+
+	sort.SliceStable(usage.Children, func(i, j int) bool {
+		return usage.Children[i].Size > usage.Children[j].Size
+	})
+
+	return usage
+}
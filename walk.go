@@ -0,0 +1,208 @@
+package clouddriveclient
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+)
+
+const DefaultWalkConcurrency = 4
+
+// SkipDir can be returned from a WalkFunc to skip the folder it was called
+// with. Returned for a file, it skips the remaining files in its folder.
+var SkipDir = fs.SkipDir
+
+// SkipAll can be returned from a WalkFunc to stop the walk without error.
+var SkipAll = fs.SkipAll
+
+// WalkFunc is called for every node visited by Walk. path is relative to the
+// walk root, which itself is visited with path ".". If listing a folder
+// fails, fn is called a second time for that folder with the error.
+type WalkFunc func(path string, node *Node, err error) error
+
+type WalkErrorPolicy int
+
+const (
+	// WalkErrorReport passes listing errors to the WalkFunc (the default).
+	WalkErrorReport WalkErrorPolicy = iota
+	// WalkErrorSkip silently skips folders that could not be listed.
+	WalkErrorSkip
+	// WalkErrorAbort stops the walk on the first listing error.
+	WalkErrorAbort
+)
+
+type WalkOptions struct {
+	// Concurrency is the number of folders listed in parallel.
+	Concurrency int
+	// ErrorPolicy controls what happens when a folder can't be listed.
+	ErrorPolicy WalkErrorPolicy
+}
+
+// Walk walks the tree rooted at rootId, calling fn for each node including
+// the root. It works like filepath.WalkDir except that folders are listed
+// concurrently, so siblings in different folders are not visited in lexical
+// order. A folder is always visited before its children and fn is never
+// called concurrently.
+func (d *CloudDrive) Walk(ctx context.Context, rootId string, fn WalkFunc) error {
+	return d.WalkWithOptions(ctx, rootId, nil, fn)
+}
+
+func (d *CloudDrive) WalkWithOptions(ctx context.Context, rootId string, opts *WalkOptions, fn WalkFunc) error {
+	if opts == nil {
+		opts = &WalkOptions{}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultWalkConcurrency
+	}
+
+	root, err := d.LookupNodeById(ctx, rootId)
+	if err != nil {
+		err = fn(".", nil, err)
+		if err == SkipDir || err == SkipAll {
+			return nil
+		}
+		return err
+	}
+
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		d:      d,
+		ctx:    walkCtx,
+		cancel: cancel,
+		policy: opts.ErrorPolicy,
+		fn:     fn,
+		sem:    make(chan struct{}, concurrency),
+	}
+
+	err = w.call(".", root, nil)
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if root.Kind == NodeKindFolder {
+		w.wg.Add(1)
+		go w.list(".", root)
+	}
+
+	w.wg.Wait()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if !w.stopped {
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+type walker struct {
+	d      *CloudDrive
+	ctx    context.Context
+	cancel context.CancelFunc
+	policy WalkErrorPolicy
+	fn     WalkFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mutex   sync.Mutex
+	stopped bool
+	err     error
+}
+
+func (w *walker) call(p string, node *Node, err error) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.stopped {
+		return SkipAll
+	}
+
+	err = w.fn(p, node, err)
+
+	if err != nil && err != SkipDir {
+		w.stopped = true
+		if err != SkipAll {
+			w.err = err
+		}
+		w.cancel()
+	}
+
+	return err
+}
+
+func (w *walker) stop(err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.stopped {
+		w.stopped = true
+		w.err = err
+		w.cancel()
+	}
+}
+
+func (w *walker) list(p string, dir *Node) {
+	defer w.wg.Done()
+
+	select {
+	case w.sem <- struct{}{}:
+	case <-w.ctx.Done():
+		return
+	}
+
+	children, err := w.d.NodeChildren(w.ctx, dir.Id)
+
+	<-w.sem
+
+	if err != nil {
+		if w.ctx.Err() != nil {
+			return
+		}
+
+		switch w.policy {
+		case WalkErrorSkip:
+		case WalkErrorAbort:
+			w.stop(err)
+		default:
+			w.call(p, dir, err)
+		}
+
+		return
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name < children[j].Name
+	})
+
+	for _, child := range children {
+		childPath := path.Join(p, child.Name)
+
+		err := w.call(childPath, child, nil)
+
+		if err == SkipDir {
+			if child.Kind == NodeKindFolder {
+				continue
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if child.Kind == NodeKindFolder {
+			w.wg.Add(1)
+			go w.list(childPath, child)
+		}
+	}
+}
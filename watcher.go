@@ -0,0 +1,252 @@
+package clouddriveclient
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultWatchInterval = 30 * time.Second
+
+// CheckpointStore persists the changes feed checkpoint of a Watcher.
+type CheckpointStore interface {
+	LoadCheckpoint() (checkpoint string, err error)
+	SaveCheckpoint(checkpoint string) error
+}
+
+type MemoryCheckpointStore struct {
+	checkpoint string
+	mutex      sync.Mutex
+}
+
+func (s *MemoryCheckpointStore) LoadCheckpoint() (checkpoint string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.checkpoint, nil
+}
+
+func (s *MemoryCheckpointStore) SaveCheckpoint(checkpoint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.checkpoint = checkpoint
+
+	return nil
+}
+
+// FileCheckpointStore keeps the checkpoint in file Path. A missing file
+// means no checkpoint.
+type FileCheckpointStore struct {
+	Path string
+}
+
+func (s *FileCheckpointStore) LoadCheckpoint() (checkpoint string, err error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (s *FileCheckpointStore) SaveCheckpoint(checkpoint string) error {
+	tmp := s.Path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, []byte(checkpoint), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.Path)
+}
+
+// ChangeEvent is a change set delivered by Watcher.Events. Reset means the
+// change set starts a full resync and local state should be discarded
+// before applying it. Err is set on the last event if the watcher failed.
+type ChangeEvent struct {
+	Changes *Changes
+	Reset   bool
+	Err     error
+	// Done must be called once the change set is handled. The watcher waits
+	// for it and saves the checkpoint only if err is nil, otherwise it
+	// stops with err. Done is nil on error events.
+	Done func(err error)
+}
+
+// Watcher polls the changes feed and delivers change sets to OnChanges,
+// persisting the checkpoint after each one is handled.
+type Watcher struct {
+	Drive    *CloudDrive
+	Store    CheckpointStore
+	Interval time.Duration
+	// Options are used for every changes request. Checkpoint is ignored.
+	Options ChangesOptions
+
+	// OnReset is called before the change sets of a full resync.
+	OnReset func(ctx context.Context) error
+	// OnChanges is called for every change set. Its checkpoint is saved only
+	// if OnChanges returns nil, otherwise the watcher stops.
+	OnChanges func(ctx context.Context, changes *Changes) error
+	// OnError is called when polling fails. Returning nil retries at the next
+	// interval, returning an error stops the watcher. Without OnError the
+	// watcher stops on the first error.
+	OnError func(err error) error
+
+	// onEnd is called when a poll reaches the end of the feed.
+	onEnd func() error
+}
+
+func NewWatcher(d *CloudDrive, store CheckpointStore) *Watcher {
+	if store == nil {
+		store = &MemoryCheckpointStore{}
+	}
+
+	return &Watcher{
+		Drive:    d,
+		Store:    store,
+		Interval: DefaultWatchInterval,
+	}
+}
+
+// Run polls until ctx is cancelled, in which case it returns nil.
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+
+	for {
+		err := w.Poll(ctx)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			if w.OnError == nil {
+				return err
+			}
+			if err := w.OnError(err); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Poll reads all pending changes once.
+func (w *Watcher) Poll(ctx context.Context) error {
+	for {
+		checkpoint, err := w.Store.LoadCheckpoint()
+		if err != nil {
+			return err
+		}
+
+		opts := w.Options
+		opts.Checkpoint = checkpoint
+
+		received := false
+
+		end, err := w.Drive.ChangesStream(ctx, &opts, func(changes *Changes) error {
+			received = true
+
+			if changes.Reset && w.OnReset != nil {
+				if err := w.OnReset(ctx); err != nil {
+					return err
+				}
+			}
+
+			if w.OnChanges != nil {
+				if err := w.OnChanges(ctx, changes); err != nil {
+					return err
+				}
+			}
+
+			if changes.Checkpoint == "" {
+				return nil
+			}
+
+			return w.Store.SaveCheckpoint(changes.Checkpoint)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// the response was cut short (e.g. by MaxNodes), continue from the
+		// saved checkpoint
+		if !end && received {
+			continue
+		}
+
+		if end && w.onEnd != nil {
+			return w.onEnd()
+		}
+
+		return nil
+	}
+}
+
+// Events runs the watcher and delivers change sets on the returned channel
+// instead of OnReset and OnChanges. Each event must be acknowledged with
+// Done before the next one is delivered. The channel is closed when the
+// watcher stops.
+func (w *Watcher) Events(ctx context.Context) <-chan *ChangeEvent {
+	events := make(chan *ChangeEvent)
+
+	watcher := *w
+
+	watcher.OnReset = nil
+	watcher.OnChanges = func(ctx context.Context, changes *Changes) error {
+		done := make(chan error, 1)
+
+		var once sync.Once
+
+		event := &ChangeEvent{
+			Changes: changes,
+			Reset:   changes.Reset,
+			Done: func(err error) {
+				once.Do(func() {
+					done <- err
+				})
+			},
+		}
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	go func() {
+		defer close(events)
+
+		if err := watcher.Run(ctx); err != nil {
+			select {
+			case events <- &ChangeEvent{Err: err}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return events
+}